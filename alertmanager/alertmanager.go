@@ -4,6 +4,7 @@
 package alertmanager
 
 import (
+	"fmt"
 	"sort"
 	"time"
 )
@@ -14,6 +15,11 @@ const (
 
 	// AlertFiring is the status value for a firing alert.
 	AlertFiring = "firing"
+	// AlertResolved is the status value for a resolved alert.
+	AlertResolved = "resolved"
+
+	// Version is the version of the webhook payload format.
+	Version = "4"
 )
 
 // Pair is a key/value string pair.
@@ -84,6 +90,17 @@ func (kv KV) Remove(keys []string) KV {
 	return res
 }
 
+// Only returns a copy of the key/value set restricted to the given keys.
+func (kv KV) Only(keys []string) KV {
+	res := KV{}
+	for _, k := range keys {
+		if v, ok := kv[k]; ok {
+			res[k] = v
+		}
+	}
+	return res
+}
+
 // Names returns the names of the label names in the LabelSet.
 func (kv KV) Names() []string {
 	return kv.SortedPairs().Names()
@@ -99,6 +116,10 @@ func (kv KV) Values() []string {
 // End-users should not be exposed to Go's type system, as this will confuse them and prevent
 // simple things like simple equality checks to fail. Map everything to float64/string.
 type Data struct {
+	Version         string `json:"version"`
+	GroupKey        string `json:"groupKey"`
+	TruncatedAlerts uint64 `json:"truncatedAlerts"`
+
 	Receiver string `json:"receiver"`
 	Status   string `json:"status"`
 	Alerts   Alerts `json:"alerts"`
@@ -110,6 +131,15 @@ type Data struct {
 	ExternalURL string `json:"externalURL"`
 }
 
+// CheckVersion returns an error if the payload has a version other than Version. Payloads without a version, as
+// sent by hand, are accepted.
+func (d *Data) CheckVersion() error {
+	if d.Version != "" && d.Version != Version {
+		return fmt.Errorf("unsupported webhook payload version %q, expected %q", d.Version, Version)
+	}
+	return nil
+}
+
 // Alert holds one alert for notification templates.
 type Alert struct {
 	Status       string    `json:"status"`
@@ -118,6 +148,7 @@ type Alert struct {
 	StartsAt     time.Time `json:"startsAt"`
 	EndsAt       time.Time `json:"endsAt"`
 	GeneratorURL string    `json:"generatorURL"`
+	Fingerprint  string    `json:"fingerprint"`
 }
 
 // Alerts is a list of Alert objects.
@@ -133,3 +164,14 @@ func (as Alerts) Firing() []Alert {
 	}
 	return res
 }
+
+// Resolved returns the subset of alerts that are resolved.
+func (as Alerts) Resolved() []Alert {
+	res := []Alert{}
+	for _, a := range as {
+		if a.Status == AlertResolved {
+			res = append(res, a)
+		}
+	}
+	return res
+}
@@ -0,0 +1,205 @@
+package jiralert
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication types of an APIConfig.
+const (
+	// AuthBasic authenticates with the user and password (the default).
+	AuthBasic = "basic"
+	// AuthBearer authenticates with a bearer token, e.g. a JIRA personal access token.
+	AuthBearer = "bearer"
+	// AuthOAuth1 authenticates with OAuth 1.0a RSA-SHA1 signatures, as supported by JIRA Server application links.
+	AuthOAuth1 = "oauth1"
+)
+
+// newAuthTransport returns a RoundTripper adding the credentials of the API configuration to every request sent
+// through base.
+func newAuthTransport(a *APIConfig, base http.RoundTripper) (http.RoundTripper, error) {
+	switch a.Auth {
+	case "", AuthBasic:
+		return &basicAuthTransport{user: a.User, password: a.Password, base: base}, nil
+	case AuthBearer:
+		if a.Token == "" {
+			return nil, fmt.Errorf("JIRA endpoint %q: bearer authentication requires a token", a.Name)
+		}
+		return &bearerAuthTransport{token: a.Token, base: base}, nil
+	case AuthOAuth1:
+		if a.ConsumerKey == "" || a.PrivateKeyFile == "" || a.AccessToken == "" {
+			return nil, fmt.Errorf("JIRA endpoint %q: oauth1 authentication requires a consumer key, a private key file and an access token", a.Name)
+		}
+		key, err := loadRSAPrivateKey(a.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("JIRA endpoint %q: %s", a.Name, err)
+		}
+		return &oauth1Transport{consumerKey: a.ConsumerKey, token: a.AccessToken, key: key, base: base}, nil
+	default:
+		return nil, fmt.Errorf("JIRA endpoint %q: unknown authentication type %q", a.Name, a.Auth)
+	}
+}
+
+type basicAuthTransport struct {
+	user, password string
+	base           http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.user == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = cloneRequest(req)
+	req.SetBasicAuth(t.user, t.password)
+	return t.base.RoundTrip(req)
+}
+
+type bearerAuthTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *bearerAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// oauth1Transport signs requests following OAuth 1.0a (RFC 5849) with the RSA-SHA1 method.
+type oauth1Transport struct {
+	consumerKey, token string
+	key                *rsa.PrivateKey
+	base               http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *oauth1Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     t.consumerKey,
+		"oauth_nonce":            base64.RawURLEncoding.EncodeToString(nonce),
+		"oauth_signature_method": "RSA-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            t.token,
+		"oauth_version":          "1.0",
+	}
+	digest := sha1.Sum([]byte(oauth1BaseString(req, oauthParams)))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	oauthParams["oauth_signature"] = base64.StdEncoding.EncodeToString(signature)
+
+	names := make([]string, 0, len(oauthParams))
+	for name := range oauthParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	header := make([]string, 0, len(names))
+	for _, name := range names {
+		header = append(header, fmt.Sprintf("%s=%q", percentEncode(name), percentEncode(oauthParams[name])))
+	}
+
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", "OAuth "+strings.Join(header, ", "))
+	return t.base.RoundTrip(req)
+}
+
+// oauth1BaseString returns the signature base string of the request (RFC 5849 section 3.4.1). JIRA requests carry JSON
+// bodies, so only the query and OAuth parameters are signed.
+func oauth1BaseString(req *http.Request, oauthParams map[string]string) string {
+	var params []string
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, percentEncode(name)+"="+percentEncode(value))
+		}
+	}
+	for name, value := range oauthParams {
+		params = append(params, percentEncode(name)+"="+percentEncode(value))
+	}
+	sort.Strings(params)
+
+	baseURL := url.URL{
+		Scheme: strings.ToLower(req.URL.Scheme),
+		Host:   strings.ToLower(req.URL.Host),
+		Path:   req.URL.EscapedPath(),
+	}
+	if (baseURL.Scheme == "http" && strings.HasSuffix(baseURL.Host, ":80")) ||
+		(baseURL.Scheme == "https" && strings.HasSuffix(baseURL.Host, ":443")) {
+		baseURL.Host = baseURL.Host[:strings.LastIndex(baseURL.Host, ":")]
+	}
+
+	return strings.Join([]string{
+		strings.ToUpper(req.Method),
+		percentEncode(baseURL.Scheme + "://" + baseURL.Host + baseURL.Path),
+		percentEncode(strings.Join(params, "&")),
+	}, "&")
+}
+
+// percentEncode encodes s as required by RFC 5849 section 3.6: everything but unreserved characters is escaped.
+func percentEncode(s string) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '.' || b == '_' || b == '~' {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+// loadRSAPrivateKey reads a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func loadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key %s: %s", path, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an RSA key", path)
+	}
+	return key, nil
+}
+
+// cloneRequest returns a shallow copy of req with its own headers, as a RoundTripper must not modify the request.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = append([]string(nil), v...)
+	}
+	return clone
+}
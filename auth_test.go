@@ -0,0 +1,137 @@
+package jiralert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "jiralert.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		api  APIConfig
+		// check returns why the request is not authenticated as expected, an empty string if it is
+		check func(req *http.Request) string
+	}{
+		{
+			name: AuthBasic,
+			api:  APIConfig{User: "jiralert", Password: "secret"},
+			check: func(req *http.Request) string {
+				if user, password, ok := req.BasicAuth(); !ok || user != "jiralert" || password != "secret" {
+					return "want basic credentials jiralert:secret"
+				}
+				return ""
+			},
+		},
+		{
+			name: "anonymous",
+			api:  APIConfig{},
+			check: func(req *http.Request) string {
+				if auth := req.Header.Get("Authorization"); auth != "" {
+					return "want no credentials"
+				}
+				return ""
+			},
+		},
+		{
+			name: AuthBearer,
+			api:  APIConfig{Auth: AuthBearer, Token: "t0ken"},
+			check: func(req *http.Request) string {
+				if req.Header.Get("Authorization") != "Bearer t0ken" {
+					return "want bearer token t0ken"
+				}
+				return ""
+			},
+		},
+		{
+			name: AuthOAuth1,
+			api:  APIConfig{Auth: AuthOAuth1, ConsumerKey: "jiralert", PrivateKeyFile: keyFile, AccessToken: "access"},
+			check: func(req *http.Request) string {
+				return checkOAuth1(req, &key.PublicKey, "jiralert", "access")
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeJira(t)
+			f.handler = func(w http.ResponseWriter, req *http.Request) bool {
+				if reason := tc.check(req); reason != "" {
+					t.Errorf("%s %s: Authorization %q: %s", req.Method, req.URL, req.Header.Get("Authorization"), reason)
+					http.Error(w, reason, http.StatusUnauthorized)
+					return true
+				}
+				return false
+			}
+			api := tc.api
+			api.Name, api.URL = DefaultAPI, f.URL
+			r := testAPIReceiver(t, &api, &ReceiverConfig{Comment: "{{ .Status }}"})
+
+			data := testData(nil, testAlert("alertname", "A"))
+			notify(t, r, data)
+			notify(t, r, data)
+			if issues := f.Issues(); len(issues) != 1 || len(issues[0].Comments) != 1 {
+				t.Errorf("got issues %+v, want one issue commented once", issues)
+			}
+		})
+	}
+}
+
+// checkOAuth1 verifies the OAuth 1.0a RSA-SHA1 signature of the request.
+func checkOAuth1(req *http.Request, key *rsa.PublicKey, consumerKey, token string) string {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "OAuth ") {
+		return "want OAuth credentials"
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(header, "OAuth "), ", ") {
+		i := strings.Index(param, "=")
+		if i < 0 {
+			return "malformed parameter " + param
+		}
+		value, err := strconv.Unquote(param[i+1:])
+		if err == nil {
+			value, err = url.PathUnescape(value)
+		}
+		if err != nil {
+			return "malformed parameter " + param
+		}
+		params[param[:i]] = value
+	}
+	if params["oauth_consumer_key"] != consumerKey || params["oauth_token"] != token || params["oauth_signature_method"] != "RSA-SHA1" {
+		return "want the consumer key, access token and RSA-SHA1 signature method"
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["oauth_signature"])
+	if err != nil {
+		return "malformed signature"
+	}
+	delete(params, "oauth_signature")
+
+	// The server sees the request path only, sign the URL the client used.
+	signed := req.Clone(req.Context())
+	signed.URL.Scheme, signed.URL.Host = "http", req.Host
+	digest := sha1.Sum([]byte(oauth1BaseString(signed, params)))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], signature); err != nil {
+		return "invalid signature: " + err.Error()
+	}
+	return ""
+}
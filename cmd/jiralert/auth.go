@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tixu/jiralert"
+)
+
+const (
+	// signatureHeader carries the hex encoded HMAC-SHA256 of the request body, prefixed with "sha256=".
+	signatureHeader = "X-Jiralert-Signature"
+	// maxSignedBodySize is the largest request body read to verify its signature.
+	maxSignedBodySize = 10 << 20
+)
+
+// publicPaths are served without authentication.
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/metrics": true,
+}
+
+// authHandler checks the credentials of every request before passing it to next: `/alert` requires the webhook
+// credentials, all other endpoints but the public ones require the admin credentials. With mutual TLS, they also
+// require a verified client certificate. The credentials of the active configuration are used, so they are rotated by
+// a reload.
+func authHandler(configs *reloader, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if publicPaths[req.URL.Path] {
+			next.ServeHTTP(w, req)
+			return
+		}
+		snap := configs.Snapshot()
+		auth := &snap.config.Auth
+		conf := &auth.Admin
+		if req.URL.Path == "/alert" {
+			conf = &auth.Webhook
+		}
+		var (
+			ok     bool
+			reason string
+		)
+		if snap.tls != nil && snap.tls.ClientCAs != nil && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			reason = "missing client certificate"
+		} else {
+			ok, reason = authenticate(w, conf, req)
+		}
+		if !ok {
+			log.Warnf("rejected request to %s from %s: %s", req.URL.Path, req.RemoteAddr, reason)
+			requestTotal.WithLabelValues(unknownReceiver, strconv.Itoa(http.StatusUnauthorized)).Inc()
+			if conf.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="jiralert"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// authenticate returns whether the request carries the configured credentials, and why not. The body of signed
+// requests is read in memory, up to maxSignedBodySize bytes.
+func authenticate(w http.ResponseWriter, conf *jiralert.HTTPAuthConfig, req *http.Request) (bool, string) {
+	if conf.Username != "" || conf.BearerToken != "" {
+		authorized := false
+		header := req.Header.Get("Authorization")
+		if user, password, ok := req.BasicAuth(); ok {
+			authorized = conf.Username != "" && secureCompare(user, conf.Username) && secureCompare(password, conf.Password)
+		} else if strings.HasPrefix(header, "Bearer ") {
+			authorized = conf.BearerToken != "" && secureCompare(strings.TrimPrefix(header, "Bearer "), conf.BearerToken)
+		}
+		if !authorized {
+			return false, "missing or invalid credentials"
+		}
+	}
+
+	if conf.HMACSecret != "" {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxSignedBodySize))
+		req.Body.Close()
+		if err != nil {
+			return false, "cannot read body: " + err.Error()
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		mac := hmac.New(sha256.New, []byte(conf.HMACSecret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(req.Header.Get(signatureHeader)), []byte(expected)) {
+			return false, "missing or invalid " + signatureHeader + " header"
+		}
+	}
+	return true, ""
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
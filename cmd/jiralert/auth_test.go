@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tixu/jiralert"
+)
+
+// testReloader returns a reloader whose active snapshot holds config.
+func testReloader(config *jiralert.Config) *reloader {
+	r := &reloader{}
+	r.current.Store(&snapshot{config: config})
+	return r
+}
+
+// rejected returns the number of requests rejected as unauthorized so far.
+func rejected(t *testing.T) float64 {
+	var m dto.Metric
+	if err := requestTotal.WithLabelValues(unknownReceiver, strconv.Itoa(http.StatusUnauthorized)).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthHandler(t *testing.T) {
+	configs := testReloader(&jiralert.Config{Auth: jiralert.ServerAuthConfig{
+		Webhook: jiralert.HTTPAuthConfig{Username: "alertmanager", Password: "secret", HMACSecret: "hmac"},
+		Admin:   jiralert.HTTPAuthConfig{BearerToken: "admin"},
+	}})
+	var body string
+	handler := authHandler(configs, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}))
+
+	const payload = `{"receiver":"jira"}`
+	for _, tc := range []struct {
+		name      string
+		path      string
+		body      string
+		user      string
+		password  string
+		token     string
+		signature string
+		want      int
+	}{
+		{name: "webhook", path: "/alert", body: payload, user: "alertmanager", password: "secret", signature: sign("hmac", payload), want: http.StatusOK},
+		{name: "webhook without signature", path: "/alert", body: payload, user: "alertmanager", password: "secret", want: http.StatusUnauthorized},
+		{name: "webhook with invalid signature", path: "/alert", body: payload, user: "alertmanager", password: "secret", signature: sign("other", payload), want: http.StatusUnauthorized},
+		{name: "webhook with wrong password", path: "/alert", body: payload, user: "alertmanager", password: "wrong", signature: sign("hmac", payload), want: http.StatusUnauthorized},
+		{name: "webhook with admin credentials", path: "/alert", body: payload, token: "admin", signature: sign("hmac", payload), want: http.StatusUnauthorized},
+		{name: "webhook with oversized body", path: "/alert", body: strings.Repeat(" ", maxSignedBodySize+1), user: "alertmanager", password: "secret", signature: sign("hmac", strings.Repeat(" ", maxSignedBodySize+1)), want: http.StatusUnauthorized},
+		{name: "admin", path: "/config", token: "admin", want: http.StatusOK},
+		{name: "admin with wrong token", path: "/config", token: "wrong", want: http.StatusUnauthorized},
+		{name: "admin with webhook credentials", path: "/config", user: "alertmanager", password: "secret", want: http.StatusUnauthorized},
+		{name: "admin without credentials", path: "/reload", want: http.StatusUnauthorized},
+		{name: "public", path: "/healthz", want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body = ""
+			before := rejected(t)
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			switch {
+			case tc.user != "":
+				req.SetBasicAuth(tc.user, tc.password)
+			case tc.token != "":
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			if tc.signature != "" {
+				req.Header.Set(signatureHeader, tc.signature)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.want {
+				t.Fatalf("got status %d, want %d", w.Code, tc.want)
+			}
+			if tc.want == http.StatusOK && body != tc.body {
+				t.Errorf("got body %q, want %q passed on", body, tc.body)
+			}
+			wantRejected := before
+			if tc.want == http.StatusUnauthorized {
+				wantRejected++
+			}
+			if got := rejected(t); got != wantRejected {
+				t.Errorf("got %v rejected requests, want %v", got, wantRejected)
+			}
+		})
+	}
+}
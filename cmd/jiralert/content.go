@@ -45,7 +45,7 @@ const (
           <div><a href="/metrics">Metrics</a></div>
           <div><a href="/logs">Logs</a></div>
           <div><a href="/debug/pprof">Profiling</a></div>
-          <div><a href="/reload">Reload</a></div>
+          <form method="post" action="/reload"><a href="#" onclick="this.parentNode.submit(); return false;">Reload</a></form>
         </div>
         {{template "content" .}}
       </body>
@@ -59,7 +59,8 @@ const (
     {{ define "content.config" -}}
       <h2>Status</h2>
       <div class="config">
-        start :  {{.Status.start}}
+        start :  {{.Status.start}} <br/>
+        last successful reload :  {{.Status.reload}}
       </div>
       <h2>Build</h2>
       <div class="config">
@@ -68,11 +69,14 @@ const (
         hash: {{.Version.hash}}<br/>
 
       </div>
-      <h2>Endpoint </h2>
+      <h2>Endpoints </h2>
+      {{ range .Endpoints -}}
        <div class="config">
-            url :   {{.Endpoint.url}} <br/>
-            user :  {{.Endpoint.user}} <br/>
+            name :  {{.Name}} <br/>
+            url :   {{.URL}} <br/>
+            user :  {{.User}} <br/>
        </div>
+      {{- end }}
       <h2>Runtime </h2>
       <div class="config">
         data :   {{.Runtime.data}} </br>
@@ -121,22 +125,27 @@ func LogsHandlerFunc() func(http.ResponseWriter, *http.Request) {
 }
 
 // ConfigHandlerFunc is the HTTP handler for the `/config` page. It outputs the configuration marshaled in YAML format.
-func ConfigHandlerFunc(config *jiralert.Config) func(http.ResponseWriter, *http.Request) {
+func ConfigHandlerFunc(configs *reloader) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		snap := configs.Snapshot()
+		config := snap.config
 		log.Infof("config %s", config.String())
-		jira := map[string]string{"url": *jiraurl, "user": *jirauser}
+		var apis []jiralert.APIConfig
+		for _, a := range config.APIs {
+			apis = append(apis, jiralert.APIConfig{Name: a.Name, URL: a.URL, User: a.User})
+		}
 		runtime := map[string]string{"data": *dataDir, "log": *logLevel}
 		version := map[string]string{"version": Version, "buildate": BuildDate, "hash": Hash}
-		status := map[string]string{"start": startDate}
+		status := map[string]string{"start": startDate, "reload": snap.loaded.Format("2006-01-02 15:04:05")}
 		data := struct {
-			Config   string
-			Endpoint map[string]string
-			Runtime  map[string]string
-			Version  map[string]string
-			Status   map[string]string
+			Config    string
+			Endpoints []jiralert.APIConfig
+			Runtime   map[string]string
+			Version   map[string]string
+			Status    map[string]string
 		}{
 			config.String(),
-			jira,
+			apis,
 			runtime,
 			version,
 			status,
@@ -29,16 +29,26 @@ const (
 )
 
 var (
-	listenAddress = flag.String("listen-address", ":9097", "The address to listen on for HTTP requests.")
-	configFile    = flag.String("config", "config", "The JIRAlert configuration file")
-	dbFileName    string
-	logFileName   string
-	jirauser      = flag.String("jirauser", "jirauser", "The user accessing JIRA")
-	jirapassword  = flag.String("jirapassword", "jirapassword", "The user's password accessing JIRA")
-	jiraurl       = flag.String("jiraurl", "https://jira.smals.be", "The Jira url")
-	logLevel      = flag.String("loglevel", "PROD", "log level either PROD or DEV")
-	dataDir       = flag.String("datadir", ".", "location of temporaty file")
-	startDate     string
+	listenAddress    = flag.String("listen-address", ":9097", "The address to listen on for HTTP requests.")
+	configFile       = flag.String("config", "config", "The JIRAlert configuration file")
+	dbFileName       string
+	logFileName      string
+	jirauser         = flag.String("jirauser", "jirauser", "The user accessing JIRA")
+	jirapassword     = flag.String("jirapassword", "jirapassword", "The user's password accessing JIRA, ${VAR} references are expanded")
+	jirapasswordFile = flag.String("jirapassword-file", "", "The file containing the user's password accessing JIRA, read again on reload")
+	jiraurl          = flag.String("jiraurl", "https://jira.smals.be", "The Jira url")
+	logLevel         = flag.String("loglevel", "PROD", "log level either PROD or DEV")
+	dataDir          = flag.String("datadir", ".", "location of temporaty file")
+	watchConfig      = flag.Bool("watch-config", false, "Reload the configuration when the configuration, template or TLS files change")
+	tlsCertFile      = flag.String("tls-cert-file", "", "The TLS certificate of the HTTP listener, enables HTTPS")
+	tlsKeyFile       = flag.String("tls-key-file", "", "The TLS key of the HTTP listener")
+	tlsClientCAFile  = flag.String("tls-client-ca-file", "", "The CA bundle verifying client certificates, enables mutual TLS")
+	storeType        = flag.String("store", "bolt", "The issue store, either bolt (in the database), file (jiralert-issues.json) or memory")
+	queueWorkers     = flag.Int("queue-workers", 4, "The number of notifications delivered to JIRA concurrently")
+	queueMaxAttempts = flag.Int("queue-max-attempts", 10, "The number of delivery attempts before a notification is dead-lettered")
+	queueMinBackoff  = flag.Duration("queue-min-backoff", time.Second, "The initial retry delay after a failed delivery")
+	queueMaxBackoff  = flag.Duration("queue-max-backoff", 5*time.Minute, "The maximum retry delay after failed deliveries")
+	startDate        string
 
 	// Version is the build version, set by make to latest git tag/hash via `-ldflags "-X main.Version=$(VERSION)"`.
 	Version = "<local build>"
@@ -47,9 +57,6 @@ var (
 	// Hash is the git hash
 	Hash = "<hash>"
 
-	cfg  = &jiralert.Config{}
-	tmpl = &jiralert.Template{}
-
 	// Metrics related variable.
 	MGroupIn      = stats.Int64("jira/group_in", "The number of jira group received", "1")
 	MAlarmIn      = stats.Int64("jira/alarm_in", "The number of alarms we received", "1")
@@ -79,9 +86,24 @@ var (
 		Description: "The number of reload request received",
 		Aggregation: view.Count(),
 	}
+	ThrottledCountView = &view.View{
+		Name:        "jiralert/jira_throttled",
+		Measure:     jiralert.MThrottled,
+		TagKeys:     []tag.Key{jiralert.EndpointKey, jiralert.ReasonKey},
+		Description: "The number of JIRA requests delayed by the rate limit or rejected by the circuit breaker",
+		Aggregation: view.Count(),
+	}
+	BreakerStateView = &view.View{
+		Name:        "jiralert/jira_circuit_breaker_state",
+		Measure:     jiralert.MBreakerState,
+		TagKeys:     []tag.Key{jiralert.EndpointKey},
+		Description: "The circuit breaker state of the JIRA endpoints: 0 closed, 1 open, 2 half-open",
+		Aggregation: view.LastValue(),
+	}
 )
 
-func init() {
+// setup parses the flags and sets up logging. It is not an init function so that the package can be tested.
+func setup() {
 	startDate = time.Now().Format("2006-01-02 15:04:05")
 	flag.Parse()
 	logFileName = *dataDir + "/logfile.log"
@@ -109,70 +131,63 @@ func init() {
 	} else {
 		log.SetOutput(mw)
 	}
-
 }
+
 func main() {
+	setup()
+	if flag.Arg(0) == "render" {
+		os.Exit(renderCommand(flag.Args()[1:]))
+	}
 
-	exporter, err := prometheus.NewExporter(prometheus.Options{})
+	exporter, err := prometheus.NewExporter(prometheus.Options{Registry: registry})
 	if err != nil {
 		log.Fatal(err)
 	}
 	view.RegisterExporter(exporter)
-	if err := view.Register(GroupCountView, AlarmsCountView, ReloadsCountView); err != nil {
+	if err := view.Register(GroupCountView, AlarmsCountView, ReloadsCountView, ThrottledCountView, BreakerStateView); err != nil {
 		log.Fatalf("Failed to register views: %v", err)
 	}
 	// Set reporting period to report data at every second.
 	view.SetReportingPeriod(10 * time.Second)
 
-	jiraEndpoint := jiralert.APIConfig{URL: *jiraurl, User: *jirauser, Password: *jirapassword}
 	log.Infof("Starting JIRAlert version %s hash %s date %s", Version, Hash, BuildDate)
-	if err = initDB(dbFileName); err != nil {
-		panic(err)
+	db, err := openDB(dbFileName)
+	if err != nil {
+		log.Fatalf("Error opening database %s: %s", dbFileName, err)
 	}
+	defer db.Close()
 
-	err = cfg.ReadConfiguration(*configFile)
+	configs, err := newReloader(*configFile)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %s", err)
 	}
+	go configs.watchSignals()
+	if *watchConfig {
+		if err := configs.watchFiles(); err != nil {
+			log.Fatalf("Error watching configuration files: %s", err)
+		}
+	}
 
-	tmpl, err = jiralert.LoadTemplate(cfg.Template)
+	store, err := newIssueStore(*storeType, db)
 	if err != nil {
-		log.Fatalf("Error loading templates from %s: %s", cfg.Template, err)
+		log.Fatalf("Error opening issue store: %s", err)
 	}
-	http.HandleFunc("/reload", func(w http.ResponseWriter, req *http.Request) {
-		log.Infof("reloading config....")
-		defer stats.Record(context.Background(), MConfigReload.M(1))
-
-		err = cfg.ReadConfiguration(*configFile)
-		if err != nil {
-			log.Fatalf("Error loading configuration: %s", err)
-			errorHandler(w, 500, err, "bad config", nil)
-			return
-		}
-
-		tmpl, err = jiralert.LoadTemplate(cfg.Template)
-		if err != nil {
-			log.Fatalf("Error loading templates from %s: %s", cfg.Template, err)
-			errorHandler(w, 500, err, "bad config", nil)
-			return
-		}
-
-		switch req.Method {
-		case http.MethodGet:
-			// Serve the resource.
-			http.Redirect(w, req, "/config", 302)
-
-		case http.MethodPost:
-			// Create a new record.
-		case http.MethodPut:
-			// Update an existing record.
-		case http.MethodDelete:
-			// Remove the record.
-		default:
-			// Give an error message.
-		}
-
+	state, err := jiralert.NewBoltStateStore(db)
+	if err != nil {
+		log.Fatalf("Error opening state store: %s", err)
+	}
+	queue, err := jiralert.NewQueue(db, deliver(configs, store, state), jiralert.QueueOptions{
+		Workers:     *queueWorkers,
+		MaxAttempts: *queueMaxAttempts,
+		MinBackoff:  *queueMinBackoff,
+		MaxBackoff:  *queueMaxBackoff,
 	})
+	if err != nil {
+		log.Fatalf("Error creating delivery queue: %s", err)
+	}
+	go queue.Run(context.Background())
+
+	http.HandleFunc("/reload", ReloadHandlerFunc(configs))
 	http.HandleFunc("/alert", func(w http.ResponseWriter, req *http.Request) {
 		log.Infof("Handling /alert webhook request")
 		// https://godoc.org/github.com/prometheus/alertmanager/template#Data
@@ -182,70 +197,51 @@ func main() {
 			return
 		}
 		defer req.Body.Close()
+		if err := data.CheckVersion(); err != nil {
+			errorHandler(w, http.StatusBadRequest, err, unknownReceiver, &data)
+			return
+		}
 		ctx, err := tag.New(context.Background(), tag.Insert(receiverKey, data.Receiver))
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer stats.Record(ctx, MGroupIn.M(1))
-		conf := cfg.ReceiverByName(data.Receiver)
+		snap := configs.Snapshot()
+		conf := snap.config.ReceiverByName(data.Receiver)
 		if conf == nil {
 			tag.Insert(statusKey, strconv.Itoa(http.StatusNotFound))
 			errorHandler(w, http.StatusNotFound, fmt.Errorf("Receiver missing: %s", data.Receiver), unknownReceiver, &data)
 			return
 		}
 		log.Infof("Matched receiver: %q", conf.Name)
+		if endpoint := snap.endpoints[conf.APIName()]; endpoint != nil && endpoint.CircuitOpen() {
+			// Let Alertmanager retry later rather than queueing notifications JIRA cannot take.
+			errorHandler(w, http.StatusServiceUnavailable, jiralert.ErrCircuitOpen, conf.Name, &data)
+			return
+		}
 
-		// Filter out resolved alerts, not interested in them.
-
-		alerts := data.Alerts.Firing()
-		if len(alerts) < len(data.Alerts) {
-			log.Warningf("Please set \"send_resolved: false\" on receiver %s in the Alertmanager config", conf.Name)
-			data.Alerts = alerts
+		// Resolved alerts are only handled by receivers with a resolve state.
+		if conf.ResolveState == "" && len(data.Alerts.Resolved()) > 0 {
+			log.Warningf("Please set \"send_resolved: false\" on receiver %s in the Alertmanager config or configure a resolvestate", conf.Name)
 		}
 
-		if len(data.Alerts) > 0 {
-			r, err := jiralert.NewReceiver(ctx, &jiraEndpoint, conf, tmpl, dbFileName)
-			if err != nil {
-				errorHandler(w, http.StatusInternalServerError, err, conf.Name, &data)
-				return
-			}
-			log.Info("able to create receiver")
-			m, err := r.Notify(ctx, &data)
-			if err != nil {
-				errorHandler(w, http.StatusInternalServerError, err, conf.Name, &data)
-				return
-			}
-			log.Infof("responses %+v", m)
-			statusJson, err := json.Marshal(m)
-			if err != nil {
-				errorHandler(w, http.StatusInternalServerError, err, conf.Name, &data)
-				return
-			}
-
-			responseStatus := 0
-			for k := range m {
-				alertctx, _ := tag.New(ctx, tag.Insert(alarmKey, k), tag.Insert(statusKey, strconv.Itoa((m[k].Status))))
-				stats.Record(alertctx, MAlarmIn.M(1))
-
-				if responseStatus == 0 {
-					responseStatus = m[k].Status
-				}
-				if responseStatus != m[k].Status {
-					responseStatus = http.StatusMultiStatus
-					break
-				}
-
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(responseStatus)
-			w.Write(statusJson)
+		id, err := queue.Enqueue(&data)
+		if err != nil {
+			errorHandler(w, http.StatusInternalServerError, err, conf.Name, &data)
+			return
 		}
+		requestTotal.WithLabelValues(conf.Name, strconv.Itoa(http.StatusAccepted)).Inc()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(struct{ ID uint64 }{id})
 	})
 
 	http.HandleFunc("/", HomeHandlerFunc())
-	http.HandleFunc("/config", ConfigHandlerFunc(cfg))
+	http.HandleFunc("/config", ConfigHandlerFunc(configs))
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { http.Error(w, "OK", http.StatusOK) })
 	http.HandleFunc("/logs", LogsHandlerFunc())
+	http.HandleFunc("/queue/deadletter", DeadLetterHandlerFunc(queue))
+	http.HandleFunc("/queue/deadletter/replay", ReplayHandlerFunc(queue))
 	http.Handle("/metrics", exporter)
 
 	if os.Getenv("PORT") != "" {
@@ -253,7 +249,15 @@ func main() {
 	}
 
 	log.Infof("Listening on %s", *listenAddress)
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	if auth := configs.Config().Auth; !auth.Webhook.Enabled() || !auth.Admin.Enabled() {
+		log.Warnf("Webhook or admin endpoints are not authenticated, configure the auth section to protect them")
+	}
+	server := &http.Server{Addr: *listenAddress, Handler: authHandler(configs, http.DefaultServeMux)}
+	if configs.Snapshot().tls != nil {
+		server.TLSConfig = serverTLSConfig(configs)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(server.ListenAndServe())
 }
 
 func errorHandler(w http.ResponseWriter, status int, err error, receiver string, data *alertmanager.Data) {
@@ -273,25 +277,53 @@ func errorHandler(w http.ResponseWriter, status int, err error, receiver string,
 	json := string(bytes[:])
 	fmt.Fprint(w, json)
 
-	log.Errorf("%d %s: err=%s receiver=%q groupLabels=%+v", status, http.StatusText(status), err, receiver, data.GroupLabels)
+	if data != nil {
+		log.Errorf("%d %s: err=%s receiver=%q groupLabels=%+v", status, http.StatusText(status), err, receiver, data.GroupLabels)
+	} else {
+		log.Errorf("%d %s: err=%s receiver=%q", status, http.StatusText(status), err, receiver)
+	}
 	requestTotal.WithLabelValues(receiver, strconv.FormatInt(int64(status), 10)).Inc()
 }
 
-func initDB(dbFile string) error {
+// loadEndpoints creates the JIRA clients of the configuration. The endpoint defined by the -jiraurl, -jirauser and
+// -jirapassword (or -jirapassword-file) flags is added as the default one unless the configuration defines it. The
+// unchanged endpoints of previous are kept.
+func loadEndpoints(config *jiralert.Config, previous map[string]*jiralert.Endpoint) (map[string]*jiralert.Endpoint, error) {
+	if config.APIByName(jiralert.DefaultAPI) == nil {
+		api := &jiralert.APIConfig{Name: jiralert.DefaultAPI, URL: *jiraurl, User: *jirauser, Password: *jirapassword}
+		if *jirapasswordFile != "" {
+			api.Password, api.PasswordFile = "", *jirapasswordFile
+		}
+		if err := api.LoadSecrets(); err != nil {
+			return nil, err
+		}
+		config.APIs = append(config.APIs, api)
+	}
+	return jiralert.NewEndpoints(config.APIs, previous)
+}
 
-	db, err := bolt.Open(dbFile, 0666, nil)
+// openDB opens the database shared by the issue cache and the delivery queue. It stays open until the process exits.
+func openDB(dbFile string) (*bolt.DB, error) {
+
+	db, err := bolt.Open(dbFile, 0666, &bolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer db.Close()
 
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte("JIRA"))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-		return nil
-	})
-	return nil
+	return db, nil
+
+}
 
+// newIssueStore returns the issue store of the given type, bolt stores are kept in db and file stores under datadir.
+func newIssueStore(storeType string, db *bolt.DB) (jiralert.IssueStore, error) {
+	switch storeType {
+	case "bolt":
+		return jiralert.NewBoltStore(db)
+	case "file":
+		return jiralert.NewFileStore(*dataDir + "/jiralert-issues.json")
+	case "memory":
+		return jiralert.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown issue store %q", storeType)
+	}
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tixu/jiralert"
+	"github.com/tixu/jiralert/alertmanager"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// deliver returns the queue DeliverFunc, notifying JIRA through the receiver matching the queued notification. The
+// whole delivery uses the configuration snapshot active when it started. When some alert groups fail, only their
+// alerts are retried.
+func deliver(configs *reloader, store jiralert.IssueStore, state jiralert.StateStore) jiralert.DeliverFunc {
+	return func(ctx context.Context, data *alertmanager.Data) error {
+		snap := configs.Snapshot()
+		ctx, err := tag.New(ctx, tag.Insert(receiverKey, data.Receiver))
+		if err != nil {
+			return err
+		}
+		conf := snap.config.ReceiverByName(data.Receiver)
+		if conf == nil {
+			return &jiralert.PermanentError{Err: fmt.Errorf("Receiver missing: %s", data.Receiver)}
+		}
+		endpoint := snap.endpoints[conf.APIName()]
+		if endpoint == nil {
+			return &jiralert.PermanentError{Err: fmt.Errorf("JIRA endpoint %q of receiver %s missing", conf.APIName(), conf.Name)}
+		}
+		r, err := jiralert.NewReceiver(ctx, endpoint, conf, snap.tmpl, store, state)
+		if err != nil {
+			return err
+		}
+		m, err := r.Notify(ctx, data)
+		if err != nil {
+			return err
+		}
+		log.Infof("responses %+v", m)
+
+		var (
+			failed []string
+			retry  alertmanager.Alerts
+		)
+		for k, status := range m {
+			alertctx, _ := tag.New(ctx, tag.Insert(alarmKey, k), tag.Insert(statusKey, strconv.Itoa(status.Status)))
+			stats.Record(alertctx, MAlarmIn.M(1))
+			if status.Status != http.StatusOK {
+				failed = append(failed, fmt.Sprintf("%s: %s", k, status.Err))
+				retry = append(retry, status.Alerts...)
+			}
+		}
+		if len(failed) > 0 {
+			retryData := *data
+			retryData.Alerts = retry
+			return &jiralert.PartialError{
+				Err:  fmt.Errorf("%d of %d issues failed: %s", len(failed), len(m), strings.Join(failed, "; ")),
+				Data: &retryData,
+			}
+		}
+		return nil
+	}
+}
+
+// DeadLetterHandlerFunc is the HTTP handler for `/queue/deadletter`, listing the dead-lettered notifications as JSON.
+func DeadLetterHandlerFunc(q *jiralert.Queue) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := q.DeadLetters()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// ReplayHandlerFunc is the HTTP handler for `/queue/deadletter/replay`. A POST moves the dead-letter item given by the
+// `id` query parameter, or all of them if no `id` is given, back to the delivery queue.
+func ReplayHandlerFunc(q *jiralert.Queue) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var ids []uint64
+		if idParam := r.URL.Query().Get("id"); idParam != "" {
+			id, err := strconv.ParseUint(idParam, 10, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid id %q", idParam), http.StatusBadRequest)
+				return
+			}
+			if err := q.Replay(id); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			ids = append(ids, id)
+		} else {
+			var err error
+			ids, err = q.ReplayAll()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct{ Replayed []uint64 }{ids})
+	}
+}
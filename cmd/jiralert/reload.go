@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"github.com/tixu/jiralert"
+	"go.opencensus.io/stats"
+)
+
+// snapshot is a consistent set of configuration, templates, JIRA endpoints and listener TLS configuration. A snapshot is never modified once
+// loaded: a reload builds a new one, so a notification started with a snapshot finishes with it.
+type snapshot struct {
+	config    *jiralert.Config
+	tmpl      *jiralert.Template
+	endpoints map[string]*jiralert.Endpoint
+	tls       *tls.Config
+	loaded    time.Time
+	// files are the configuration, template and TLS files the snapshot was loaded from.
+	files []string
+}
+
+// reloader loads snapshots from the configuration directory and swaps the active one atomically.
+type reloader struct {
+	configDir string
+
+	mu      sync.Mutex // serializes reloads
+	current atomic.Value
+}
+
+// newReloader loads the initial snapshot from configDir.
+func newReloader(configDir string) (*reloader, error) {
+	r := &reloader{configDir: configDir}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Snapshot returns the active snapshot.
+func (r *reloader) Snapshot() *snapshot {
+	return r.current.Load().(*snapshot)
+}
+
+// Config returns the configuration of the active snapshot.
+func (r *reloader) Config() *jiralert.Config {
+	return r.Snapshot().config
+}
+
+// Reload parses and validates the configuration, templates and endpoints, and makes them active if they are all
+// valid. On error the active snapshot is kept.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	log.Infof("reloading config....")
+	stats.Record(context.Background(), MConfigReload.M(1))
+
+	previous, _ := r.current.Load().(*snapshot)
+	s, err := r.load(previous)
+	if err != nil {
+		log.Errorf("Error loading configuration, keeping the previous one: %s", err)
+		configReloadSuccess.Set(0)
+		return err
+	}
+	r.current.Store(s)
+	if previous != nil {
+		jiralert.CloseDroppedEndpoints(previous.endpoints, s.endpoints)
+	}
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	log.Infof("configuration loaded")
+	return nil
+}
+
+// load reads a new snapshot, keeping the JIRA endpoints of previous (nil on the first load) whose configuration is
+// unchanged.
+func (r *reloader) load(previous *snapshot) (*snapshot, error) {
+	var previousEndpoints map[string]*jiralert.Endpoint
+	if previous != nil {
+		previousEndpoints = previous.endpoints
+	}
+	config := &jiralert.Config{}
+	if err := config.ReadConfiguration(r.configDir); err != nil {
+		return nil, err
+	}
+	endpoints, err := loadEndpoints(config, previousEndpoints)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	// The configuration file is only known to viper until the next load, r.mu serializes them.
+	files := append([]string{viper.ConfigFileUsed(), config.Template}, tlsFiles()...)
+	return &snapshot{config: config, tmpl: config.Templates(), endpoints: endpoints, tls: tlsConfig, loaded: time.Now(), files: files}, nil
+}
+
+// watchSignals reloads the configuration on every SIGHUP.
+func (r *reloader) watchSignals() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Infof("received SIGHUP")
+		r.Reload()
+	}
+}
+
+// watchFiles reloads the configuration when the configuration, template or TLS files change. The parent directories are
+// watched rather than the files, so that files replaced by a rename (e.g. Kubernetes ConfigMap updates) are noticed.
+// Events are debounced, an editor saving a file usually triggers several of them.
+func (r *reloader) watchFiles() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{}
+	files := func() map[string]bool {
+		watched := map[string]bool{}
+		for _, f := range r.Snapshot().files {
+			if f == "" {
+				continue
+			}
+			f = filepath.Clean(f)
+			watched[f] = true
+			if dir := filepath.Dir(f); !dirs[dir] {
+				if err := watcher.Add(dir); err != nil {
+					log.Warnf("cannot watch %s: %s", dir, err)
+					continue
+				}
+				dirs[dir] = true
+			}
+		}
+		return watched
+	}
+	watched := files()
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if watched[filepath.Clean(event.Name)] || strings.HasPrefix(filepath.Base(event.Name), "..") {
+					debounce = time.After(time.Second)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("file watcher error: %s", err)
+			case <-debounce:
+				debounce = nil
+				log.Infof("configuration files changed")
+				if r.Reload() == nil {
+					watched = files()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// ReloadHandlerFunc is the HTTP handler for `/reload`. A POST reloads the configuration, browsers are then redirected
+// to the `/config` page.
+func ReloadHandlerFunc(r *reloader) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.Reload(); err != nil {
+			errorHandler(w, http.StatusInternalServerError, err, "bad config", nil)
+			return
+		}
+		if strings.Contains(req.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, req, "/config", http.StatusSeeOther)
+			return
+		}
+		http.Error(w, "OK", http.StatusOK)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tixu/jiralert"
+	"github.com/tixu/jiralert/alertmanager"
+	"go.uber.org/multierr"
+)
+
+// renderCommand implements `jiralert render`: it prints the fields a receiver renders for a webhook payload, so that
+// templates can be checked without creating issues. It returns the exit code, 1 if any template failed.
+func renderCommand(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	configDir := flags.String("config", *configFile, "The directory of the JIRAlert configuration file")
+	receiver := flags.String("receiver", "", "The receiver rendering the notification, defaults to the notification's receiver")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: jiralert render [-config dir] [-receiver name] <webhook.json|->\n\n"+
+			"Prints the issue fields and comment the receiver renders for each alert group of the Alertmanager webhook "+
+			"payload, without contacting JIRA.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := readWebhook(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the webhook payload: %s\n", err)
+		return 1
+	}
+	config := &jiralert.Config{}
+	// Render never talks to JIRA, it does not need the secrets.
+	if err := config.ReadConfigurationWithoutSecrets(*configDir); err != nil {
+		for _, e := range multierr.Errors(err) {
+			fmt.Fprintf(os.Stderr, "Error loading configuration: %s\n", e)
+		}
+		return 1
+	}
+	if *receiver == "" {
+		*receiver = data.Receiver
+	}
+	conf := config.ReceiverByName(*receiver)
+	if conf == nil {
+		fmt.Fprintf(os.Stderr, "Receiver missing: %q\n", *receiver)
+		return 1
+	}
+
+	failed := false
+	for _, group := range jiralert.Render(conf, config.APIByName(conf.APIName()), config.Templates(), data) {
+		fmt.Printf("=== %s\n", group.Label)
+		for _, field := range group.Fields {
+			if field.Err != nil {
+				failed = true
+				fmt.Printf("%s: ERROR %s\n", field.Name, field.Err)
+				continue
+			}
+			if strings.Contains(field.Value, "\n") {
+				fmt.Printf("%s:\n  %s\n", field.Name, strings.Replace(field.Value, "\n", "\n  ", -1))
+				continue
+			}
+			fmt.Printf("%s: %s\n", field.Name, field.Value)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// readWebhook decodes the Alertmanager webhook payload stored in the file, or read from stdin if the file is "-".
+func readWebhook(file string) (*alertmanager.Data, error) {
+	var in io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	data := &alertmanager.Data{}
+	if err := json.NewDecoder(in).Decode(data); err != nil {
+		return nil, err
+	}
+	return data, data.CheckVersion()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn prints to the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestRenderCommand(t *testing.T) {
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "jiralert.tmpl")
+	files := map[string]string{
+		tmplFile: `{{ define "jira.summary" }}Alerts {{ .Status }}{{ end }}`,
+		filepath.Join(dir, "jiralert.yml"): `
+apis:
+  - name: default
+    url: https://jira.example.com
+    user: jiralert
+    password: secret
+receivers:
+  - name: jira
+    project: PROJ
+    issuetype: Bug
+    summary: '{{ template "jira.summary" . }}'
+    reopenstate: Reopen Issue
+    groupby: [alert]
+    fields:
+      customfield_10001: '{{ .Labels.instance }}'
+      customfield_10002: '{{ .NoSuchField }}'
+template: ` + tmplFile + `
+`,
+		filepath.Join(dir, "webhook.json"): `{
+  "version": "4",
+  "receiver": "jira",
+  "status": "firing",
+  "alerts": [{"status": "firing", "labels": {"alertname": "A", "instance": "db1"}, "fingerprint": "f1"}],
+  "groupLabels": {"alertname": "A"}
+}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var code int
+	out := captureStdout(t, func() {
+		code = renderCommand([]string{"-config", dir, filepath.Join(dir, "webhook.json")})
+	})
+	if code != 1 {
+		t.Errorf("got exit code %d, want 1 as a template failed", code)
+	}
+	for _, want := range []string{
+		"summary: Alerts firing\n",
+		"fields.customfield_10001: \"db1\"\n",
+		"fields.customfield_10002: ERROR ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("got output\n%s\nwant it to contain %q", out, want)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package main
 import "github.com/prometheus/client_golang/prometheus"
 
 var (
+	// registry holds the metrics exposed on /metrics, next to the OpenCensus views.
+	registry = prometheus.NewRegistry()
+
 	requestTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "jiralert_requests_total",
@@ -10,8 +13,16 @@ var (
 		},
 		[]string{"receiver", "code"},
 	)
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "jiralert_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "jiralert_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
 )
 
 func init() {
-	prometheus.MustRegister(requestTotal)
+	registry.MustRegister(requestTotal, configReloadSuccess, configReloadSeconds)
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsFiles returns the TLS files given on the command line, to be watched for changes.
+func tlsFiles() []string {
+	return []string{*tlsCertFile, *tlsKeyFile, *tlsClientCAFile}
+}
+
+// loadTLSConfig reads the certificate, key and client CA bundle given on the command line and returns the TLS
+// configuration of the listener, or nil if TLS is disabled. With a client CA bundle, the client certificates are
+// verified against its CAs. They are optional during the handshake, so that `/healthz` and `/metrics` stay open to
+// probes and scrapers; authHandler requires them on all other endpoints.
+func loadTLSConfig() (*tls.Config, error) {
+	if *tlsCertFile == "" && *tlsKeyFile == "" {
+		if *tlsClientCAFile != "" {
+			return nil, errors.New("-tls-client-ca-file requires -tls-cert-file and -tls-key-file")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load TLS certificate: %s", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if *tlsClientCAFile != "" {
+		pem, err := ioutil.ReadFile(*tlsClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in client CA bundle %s", *tlsClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// serverTLSConfig returns the TLS configuration of the HTTP server. It defers to the active snapshot on every
+// handshake, so certificates reloaded with the configuration are used by new connections right away.
+func serverTLSConfig(configs *reloader) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return configs.Snapshot().tls, nil
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &configs.Snapshot().tls.Certificates[0], nil
+		},
+	}
+}
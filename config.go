@@ -2,11 +2,19 @@ package jiralert
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.uber.org/multierr"
 	"gopkg.in/yaml.v2"
 )
 
@@ -14,18 +22,297 @@ var (
 	configLock = new(sync.RWMutex)
 )
 
-//APIConfig contains API access fields (URL, user and password)
+const (
+	// GroupByAlert creates one issue per alert, identified by all the alert labels.
+	GroupByAlert = "alert"
+	// GroupByGroup creates one issue per Alertmanager group, identified by the group labels.
+	GroupByGroup = "group"
+)
+
+const (
+	// DedupByLabels identifies issues by the labels of their alert group.
+	DedupByLabels = "labels"
+	// DedupByFingerprint identifies issues by the Alertmanager fingerprint of their alert, it requires GroupByAlert.
+	DedupByFingerprint = "fingerprint"
+	// DedupByGroupKey identifies issues by the Alertmanager group key, it requires GroupByGroup.
+	DedupByGroupKey = "groupkey"
+)
+
+const (
+	// CommentAlways comments on the existing issue on every notification.
+	CommentAlways = "always"
+	// CommentOnChange comments only when the rendered comment or the alert annotations changed.
+	CommentOnChange = "on-change"
+	// CommentInterval comments at most once per CommentInterval.
+	CommentInterval = "interval"
+)
+
+const (
+	// TextFormatPlain sends descriptions and comments as rendered.
+	TextFormatPlain = "plain"
+	// TextFormatWiki converts descriptions and comments from Markdown to JIRA wiki markup.
+	TextFormatWiki = "wiki"
+	// TextFormatADF converts descriptions and comments from Markdown to Atlassian Document Format, as required by the
+	// JIRA Cloud REST API v3. It is only valid on endpoints using APIVersion3.
+	TextFormatADF = "adf"
+)
+
+// Versions of the JIRA REST API.
+const (
+	// APIVersion2 is the REST API of JIRA Server, Data Center and Cloud.
+	APIVersion2 = 2
+	// APIVersion3 is the REST API of JIRA Cloud, taking Atlassian Document Format descriptions and comments and
+	// identifying users by account ID.
+	APIVersion3 = 3
+)
+
+// DefaultAPI is the name of the JIRA endpoint used by receivers that do not reference one.
+const DefaultAPI = "default"
+
+// APIConfig contains API access fields (URL, user and password) of a named JIRA endpoint
 type APIConfig struct {
-	// API access fields
-	URL      string
-	User     string
-	Password string
+	Name string
+
+	// API access fields, the password may be read from PasswordFile instead
+	URL          string
+	User         string
+	Password     string
+	PasswordFile string
+
+	// Authentication type: AuthBasic (default), AuthBearer or AuthOAuth1
+	Auth string
+	// Bearer or personal access token, may be read from TokenFile instead
+	Token     string
+	TokenFile string
+	// OAuth 1.0a consumer key, RSA private key (PEM) and access token
+	ConsumerKey    string
+	PrivateKeyFile string
+	AccessToken    string
+
+	// HTTP client settings
+	HTTPConfig HTTPClientConfig
+
+	// Protection of the JIRA instance
+	RateLimit      RateLimitConfig
+	CircuitBreaker CircuitBreakerConfig
+
+	// REST API version: APIVersion2 (default) or APIVersion3
+	APIVersion int
+}
+
+// version returns the REST API version of the endpoint.
+func (a *APIConfig) version() int {
+	if a == nil || a.APIVersion == 0 {
+		return APIVersion2
+	}
+	return a.APIVersion
+}
+
+// HTTPClientConfig configures the HTTP client talking to a JIRA endpoint.
+type HTTPClientConfig struct {
+	// CA bundle verifying the JIRA server certificate, the system roots if empty
+	CAFile string
+	// Client certificate and key, for JIRA instances requiring mutual TLS
+	CertFile string
+	KeyFile  string
+	// Disables the verification of the JIRA server certificate
+	InsecureSkipVerify bool
+	// Proxy URL, the proxy environment variables (HTTPS_PROXY, NO_PROXY, ...) apply if empty
+	ProxyURL string
+	// Request timeout, DefaultTimeout if zero
+	Timeout time.Duration
+}
+
+// RateLimitConfig configures the token bucket limiting the requests sent to a JIRA endpoint.
+type RateLimitConfig struct {
+	// Requests per second, unlimited if zero
+	Rate float64
+	// Requests that may be sent at once after an idle period, 1 if zero
+	Burst int
+}
+
+// CircuitBreakerConfig configures the circuit breaker that stops sending requests to a failing JIRA endpoint.
+type CircuitBreakerConfig struct {
+	// Consecutive server errors opening the breaker, disabled if zero
+	Failures int
+	// Time the breaker stays open before a trial request, DefaultBreakerTimeout if zero
+	Timeout time.Duration
+}
+
+// MarshalYAML implements yaml.Marshaler, hiding the credentials.
+func (a APIConfig) MarshalYAML() (interface{}, error) {
+	return struct {
+		Name           string
+		URL            string
+		User           string `yaml:",omitempty"`
+		Password       string `yaml:",omitempty"`
+		PasswordFile   string `yaml:",omitempty"`
+		Auth           string `yaml:",omitempty"`
+		Token          string `yaml:",omitempty"`
+		TokenFile      string `yaml:",omitempty"`
+		ConsumerKey    string `yaml:",omitempty"`
+		PrivateKeyFile string `yaml:",omitempty"`
+		AccessToken    string `yaml:",omitempty"`
+		HTTPConfig     HTTPClientConfig
+		RateLimit      RateLimitConfig
+		CircuitBreaker CircuitBreakerConfig
+		APIVersion     int `yaml:",omitempty"`
+	}{a.Name, a.URL, a.User, redact(a.Password), a.PasswordFile, a.Auth, redact(a.Token), a.TokenFile, a.ConsumerKey,
+		a.PrivateKeyFile, redact(a.AccessToken), a.HTTPConfig, a.RateLimit, a.CircuitBreaker, a.APIVersion}, nil
+}
+
+// LoadSecrets expands ${VAR} environment variable references in the API access fields, then reads the password and
+// token from PasswordFile and TokenFile if they are set. It is called on every configuration load, so rotated secrets
+// are picked up by a reload. It returns all the problems found: unset environment variables and unreadable files.
+func (a *APIConfig) LoadSecrets() error {
+	owner := fmt.Sprintf("JIRA endpoint %q", a.Name)
+	errs := expandEnvFields(owner, []secretField{
+		{"url", &a.URL}, {"user", &a.User}, {"password", &a.Password}, {"passwordfile", &a.PasswordFile},
+		{"token", &a.Token}, {"tokenfile", &a.TokenFile}, {"consumerkey", &a.ConsumerKey},
+		{"privatekeyfile", &a.PrivateKeyFile}, {"accesstoken", &a.AccessToken}, {"httpconfig.proxyurl", &a.HTTPConfig.ProxyURL},
+	})
+	var err error
+	if a.Password, err = readSecretFile(owner, "password", a.Password, a.PasswordFile); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	if a.Token, err = readSecretFile(owner, "token", a.Token, a.TokenFile); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	return errs
+}
+
+// secretField is a configuration field that may reference environment variables.
+type secretField struct {
+	name  string
+	value *string
+}
+
+// expandEnvFields expands the environment variable references of the fields in place. owner names the configuration
+// section in errors.
+func expandEnvFields(owner string, fields []secretField) error {
+	var errs error
+	for _, field := range fields {
+		var err error
+		if *field.value, err = expandEnv(*field.value); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("%s: %s: %s", owner, field.name, err))
+		}
+	}
+	return errs
+}
+
+var envReference = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// expandEnv replaces ${VAR} references with the value of the environment variable VAR. Other uses of $ are kept, so
+// that secrets containing a $ need not be escaped. Referencing an unset variable is an error, a variable set to an
+// empty string is not.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return s, fmt.Errorf("environment variable %s not set", strings.Join(missing, ", "))
+	}
+	return s, nil
+}
+
+// readSecretFile returns the content of file, without surrounding whitespace, or value if file is empty. owner names
+// the configuration section in errors.
+func readSecretFile(owner, name, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	if value != "" {
+		return "", fmt.Errorf("%s: at most one of %s and %sfile must be set", owner, name, name)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("%s: cannot read %s file: %s", owner, name, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// redact replaces a non-empty secret with a placeholder.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "<secret>"
+}
+
+// HTTPAuthConfig contains the credentials accepted by the HTTP server for a class of endpoints. Requests must carry
+// either the basic auth credentials or the bearer token, as sent by Alertmanager's http_config, and a valid HMAC body
+// signature if HMACSecret is set. No authentication is required if nothing is set.
+type HTTPAuthConfig struct {
+	Username     string
+	Password     string
+	PasswordFile string
+
+	BearerToken     string
+	BearerTokenFile string
+
+	HMACSecret     string
+	HMACSecretFile string
+}
+
+// MarshalYAML implements yaml.Marshaler, hiding the credentials.
+func (h HTTPAuthConfig) MarshalYAML() (interface{}, error) {
+	return struct {
+		Username        string `yaml:",omitempty"`
+		Password        string `yaml:",omitempty"`
+		PasswordFile    string `yaml:",omitempty"`
+		BearerToken     string `yaml:",omitempty"`
+		BearerTokenFile string `yaml:",omitempty"`
+		HMACSecret      string `yaml:",omitempty"`
+		HMACSecretFile  string `yaml:",omitempty"`
+	}{h.Username, redact(h.Password), h.PasswordFile, redact(h.BearerToken), h.BearerTokenFile, redact(h.HMACSecret),
+		h.HMACSecretFile}, nil
+}
+
+// Enabled returns whether any credential is configured.
+func (h *HTTPAuthConfig) Enabled() bool {
+	return h.Username != "" || h.Password != "" || h.BearerToken != "" || h.HMACSecret != ""
+}
+
+// LoadSecrets expands ${VAR} environment variable references and reads the secret files, like APIConfig.LoadSecrets.
+func (h *HTTPAuthConfig) LoadSecrets(name string) error {
+	errs := expandEnvFields(name, []secretField{
+		{"username", &h.Username}, {"password", &h.Password}, {"passwordfile", &h.PasswordFile},
+		{"bearertoken", &h.BearerToken}, {"bearertokenfile", &h.BearerTokenFile}, {"hmacsecret", &h.HMACSecret},
+		{"hmacsecretfile", &h.HMACSecretFile},
+	})
+	var err error
+	if h.Password, err = readSecretFile(name, "password", h.Password, h.PasswordFile); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	if h.BearerToken, err = readSecretFile(name, "bearertoken", h.BearerToken, h.BearerTokenFile); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	if h.HMACSecret, err = readSecretFile(name, "hmacsecret", h.HMACSecret, h.HMACSecretFile); err != nil {
+		errs = multierr.Append(errs, err)
+	}
+	return errs
+}
+
+// ServerAuthConfig contains the credentials of the webhook (`/alert`) and of the admin endpoints (`/reload`, `/logs`,
+// `/config`, `/debug/pprof`, ...).
+type ServerAuthConfig struct {
+	Webhook HTTPAuthConfig
+	Admin   HTTPAuthConfig
 }
 
 // ReceiverConfig is the configuration for one receiver. It has a unique name and includes and issue fields (required -- e.g. project, issue type -- and optional -- e.g. priority).
 type ReceiverConfig struct {
 	Name string
 
+	// Name of the JIRA endpoint, DefaultAPI if empty
+	API string
+
 	// Required issue fields
 	Project     string
 	IssueType   string
@@ -40,22 +327,97 @@ type ReceiverConfig struct {
 	Fields            map[string]interface{}
 	Components        []string
 
+	// Fields of existing issues updated when their rendered value changes: FieldSummary, FieldDescription,
+	// FieldPriority or the ID of one of Fields
+	UpdateFields []string
+
 	// Label copy settings
 	AddGroupLabels bool
+
+	// Issue grouping: GroupByAlert (default), GroupByGroup or a list of label names
+	GroupBy []string
+
+	// Issue identification: DedupByLabels (default), DedupByFingerprint or DedupByGroupKey
+	DedupBy string
+
+	// Resolve settings, resolved alerts are ignored if ResolveState is empty
+	ResolveState      string
+	ResolveResolution string
+
+	// Time allowed to process a notification, unlimited if zero
+	NotifyTimeout time.Duration
+
+	// Number of issues of a notification processed concurrently, 1 if zero
+	Parallelism int
+
+	// Comments on repeated notifications: CommentAlways (default), CommentOnChange or CommentInterval
+	CommentMode     string
+	CommentInterval time.Duration
+
+	// Format of the description and comments: TextFormatPlain (default), TextFormatWiki or TextFormatADF
+	TextFormat string
+
+	// Storm protection: at most MaxIssuesPerWindow issues are created per StormWindow (DefaultStormWindow if zero),
+	// the alerts of further issues are listed in a single storm issue. Unlimited if zero.
+	MaxIssuesPerWindow int
+	StormWindow        time.Duration
+}
+
+// groupByMode returns GroupByAlert or GroupByGroup if one of them is configured, an empty string when grouping by
+// an explicit list of labels.
+func (rc *ReceiverConfig) groupByMode() string {
+	switch {
+	case len(rc.GroupBy) == 0:
+		return GroupByAlert
+	case len(rc.GroupBy) == 1 && (rc.GroupBy[0] == GroupByAlert || rc.GroupBy[0] == GroupByGroup):
+		return rc.GroupBy[0]
+	default:
+		return ""
+	}
+}
+
+// parallelism returns the number of issues of a notification processed concurrently.
+func (rc *ReceiverConfig) parallelism() int {
+	if rc.Parallelism < 1 {
+		return 1
+	}
+	return rc.Parallelism
 }
 
 // Config is the top-level configuration for JIRAlert's config file.
 type Config struct {
+	Auth      ServerAuthConfig
+	APIs      []*APIConfig
 	Receivers []*ReceiverConfig
 	Template  string
 
 	// Catches all undefined fields and must be empty after parsing.
+	unknownKeys []string
+	// The templates loaded from Template and compiled by Validate.
+	tmpl *Template
+}
+
+// Templates returns the templates of the configuration, with the receiver templates compiled. It is nil until the
+// configuration is validated.
+func (c *Config) Templates() *Template {
+	return c.tmpl
 }
 
-// ReadConfiguration parses the YAML input into a Config
+// ReadConfiguration parses the YAML input into a Config, loads its secrets and validates it. The secret and validation
+// errors are all returned at once.
 func (cfg *Config) ReadConfiguration(configDir string) error {
-	configLock.RLock()
-	defer configLock.RUnlock()
+	return cfg.read(configDir, true)
+}
+
+// ReadConfigurationWithoutSecrets parses and validates the configuration like ReadConfiguration, but leaves the
+// environment variable references and secret files alone, e.g. for tools that never talk to JIRA.
+func (cfg *Config) ReadConfigurationWithoutSecrets(configDir string) error {
+	return cfg.read(configDir, false)
+}
+
+func (cfg *Config) read(configDir string, secrets bool) error {
+	configLock.Lock()
+	defer configLock.Unlock()
 	log.Info("loading configuration")
 	viper.AddConfigPath(configDir)
 	viper.SetConfigName("jiralert")
@@ -64,15 +426,211 @@ func (cfg *Config) ReadConfiguration(configDir string) error {
 		log.Warnf("got an error while reading configuration directory %s", configDir)
 		return err
 	}
-	err = viper.Unmarshal(cfg)
+	loaded := Config{}
+	var md mapstructure.Metadata
+	err = viper.Unmarshal(&loaded, func(dc *mapstructure.DecoderConfig) { dc.Metadata = &md })
 	if err != nil {
 		log.Warnf("got an error while unmarshalling configuration ")
 		return err
 
 	}
+	loaded.unknownKeys = md.Unused
+	var errs error
+	if secrets {
+		for _, a := range loaded.APIs {
+			errs = multierr.Append(errs, a.LoadSecrets())
+		}
+		errs = multierr.Append(errs, loaded.Auth.Webhook.LoadSecrets("auth.webhook"))
+		errs = multierr.Append(errs, loaded.Auth.Admin.LoadSecrets("auth.admin"))
+	}
+	errs = multierr.Append(errs, loaded.Validate())
+	if errs != nil {
+		for _, e := range multierr.Errors(errs) {
+			log.Warnf("invalid configuration: %s", e)
+		}
+		return errs
+	}
+	*cfg = loaded
 	return nil
 }
 
+// Validate checks the configuration and returns all the problems found, each prefixed with its YAML path: unknown
+// keys, missing required fields, duplicate names, references to undefined JIRA endpoints and templates that fail to
+// parse. The templates are loaded and compiled here only, Templates returns them.
+func (c *Config) Validate() error {
+	var errs error
+	required := func(path, value string) {
+		if value == "" {
+			errs = multierr.Append(errs, fmt.Errorf("%s: missing required field", path))
+		}
+	}
+
+	// Unknown keys, grouped by the YAML path of their parent.
+	unknown := map[string]map[string]interface{}{}
+	for _, key := range c.unknownKeys {
+		key = strings.ToLower(key)
+		parent, name := "config", key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			parent, name = key[:i], key[i+1:]
+		}
+		if unknown[parent] == nil {
+			unknown[parent] = map[string]interface{}{}
+		}
+		unknown[parent][name] = nil
+	}
+	parents := make([]string, 0, len(unknown))
+	for parent := range unknown {
+		parents = append(parents, parent)
+	}
+	sort.Strings(parents)
+	for _, parent := range parents {
+		errs = multierr.Append(errs, checkOverflow(unknown[parent], parent))
+	}
+
+	for _, auth := range []struct {
+		path string
+		conf *HTTPAuthConfig
+	}{{"auth.webhook", &c.Auth.Webhook}, {"auth.admin", &c.Auth.Admin}} {
+		if (auth.conf.Username == "") != (auth.conf.Password == "" && auth.conf.PasswordFile == "") {
+			errs = multierr.Append(errs, fmt.Errorf("%s: username and password must be set together", auth.path))
+		}
+	}
+
+	var tmpl *Template
+	required("template", c.Template)
+	if c.Template != "" {
+		var err error
+		if tmpl, err = LoadTemplate(c.Template); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("template: %s", err))
+		}
+	}
+
+	apis := map[string]int{}
+	for i, a := range c.APIs {
+		path := fmt.Sprintf("apis[%d]", i)
+		required(path+".name", a.Name)
+		required(path+".url", a.URL)
+		if j, ok := apis[a.Name]; ok && a.Name != "" {
+			errs = multierr.Append(errs, fmt.Errorf("%s.name: duplicate name %q, also used by apis[%d]", path, a.Name, j))
+		} else {
+			apis[a.Name] = i
+		}
+		switch a.Auth {
+		case "", AuthBasic, AuthBearer, AuthOAuth1:
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("%s.auth: unknown authentication type %q", path, a.Auth))
+		}
+		if (a.HTTPConfig.CertFile == "") != (a.HTTPConfig.KeyFile == "") {
+			errs = multierr.Append(errs, fmt.Errorf("%s.httpconfig: certfile and keyfile must be set together", path))
+		}
+		if a.HTTPConfig.ProxyURL != "" {
+			if _, err := url.Parse(a.HTTPConfig.ProxyURL); err != nil {
+				errs = multierr.Append(errs, fmt.Errorf("%s.httpconfig.proxyurl: %s", path, err))
+			}
+		}
+		if a.HTTPConfig.Timeout < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s.httpconfig.timeout: must not be negative", path))
+		}
+		if a.RateLimit.Rate < 0 || a.RateLimit.Burst < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s.ratelimit: rate and burst must not be negative", path))
+		}
+		if a.CircuitBreaker.Failures < 0 || a.CircuitBreaker.Timeout < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s.circuitbreaker: failures and timeout must not be negative", path))
+		}
+		switch a.APIVersion {
+		case 0, APIVersion2, APIVersion3:
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("%s.apiversion: must be %d or %d", path, APIVersion2, APIVersion3))
+		}
+	}
+
+	receivers := map[string]int{}
+	for i, rc := range c.Receivers {
+		path := fmt.Sprintf("receivers[%d]", i)
+		required(path+".name", rc.Name)
+		required(path+".project", rc.Project)
+		required(path+".issuetype", rc.IssueType)
+		required(path+".summary", rc.Summary)
+		required(path+".reopenstate", rc.ReopenState)
+		if j, ok := receivers[rc.Name]; ok && rc.Name != "" {
+			errs = multierr.Append(errs, fmt.Errorf("%s.name: duplicate name %q, also used by receivers[%d]", path, rc.Name, j))
+		} else {
+			receivers[rc.Name] = i
+		}
+		if _, ok := apis[rc.API]; !ok && rc.API != "" && rc.API != DefaultAPI {
+			errs = multierr.Append(errs, fmt.Errorf("%s.api: undefined JIRA endpoint %q", path, rc.API))
+		}
+		if len(rc.GroupBy) > 1 {
+			for _, label := range rc.GroupBy {
+				if label == GroupByAlert || label == GroupByGroup {
+					errs = multierr.Append(errs, fmt.Errorf("%s.groupby: %q cannot be combined with label names", path, label))
+				}
+			}
+		}
+		switch rc.DedupBy {
+		case "", DedupByLabels:
+		case DedupByFingerprint:
+			if rc.groupByMode() != GroupByAlert {
+				errs = multierr.Append(errs, fmt.Errorf("%s.dedupby: %q requires groupby %q", path, rc.DedupBy, GroupByAlert))
+			}
+		case DedupByGroupKey:
+			if rc.groupByMode() != GroupByGroup {
+				errs = multierr.Append(errs, fmt.Errorf("%s.dedupby: %q requires groupby %q", path, rc.DedupBy, GroupByGroup))
+			}
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("%s.dedupby: must be one of %q, %q or %q", path, DedupByLabels, DedupByFingerprint, DedupByGroupKey))
+		}
+		if rc.ResolveResolution != "" && rc.ResolveState == "" {
+			errs = multierr.Append(errs, fmt.Errorf("%s.resolveresolution: requires a resolvestate", path))
+		}
+		for _, name := range rc.UpdateFields {
+			if _, ok := rc.Fields[name]; !ok && name != FieldSummary && name != FieldDescription && name != FieldPriority {
+				errs = multierr.Append(errs, fmt.Errorf("%s.updatefields: %q is neither %q, %q, %q nor one of the fields",
+					path, name, FieldSummary, FieldDescription, FieldPriority))
+			}
+			if name == FieldPriority && rc.Priority == "" {
+				errs = multierr.Append(errs, fmt.Errorf("%s.updatefields: %q requires a priority", path, name))
+			}
+		}
+		switch rc.CommentMode {
+		case "", CommentAlways, CommentOnChange:
+		case CommentInterval:
+			if rc.CommentInterval <= 0 {
+				errs = multierr.Append(errs, fmt.Errorf("%s.commentinterval: must be positive with commentmode %q", path, CommentInterval))
+			}
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("%s.commentmode: must be one of %q, %q or %q", path, CommentAlways, CommentOnChange, CommentInterval))
+		}
+		switch rc.TextFormat {
+		case "", TextFormatPlain:
+		case TextFormatWiki:
+			if j, ok := apis[rc.APIName()]; ok && c.APIs[j].version() == APIVersion3 {
+				errs = multierr.Append(errs, fmt.Errorf("%s.textformat: %q is not supported by the REST API v3 of endpoint %q", path, TextFormatWiki, rc.APIName()))
+			}
+		case TextFormatADF:
+			if j, ok := apis[rc.APIName()]; !ok || c.APIs[j].version() != APIVersion3 {
+				errs = multierr.Append(errs, fmt.Errorf("%s.textformat: %q requires endpoint %q to set apiversion %d", path, TextFormatADF, rc.APIName(), APIVersion3))
+			}
+		default:
+			errs = multierr.Append(errs, fmt.Errorf("%s.textformat: must be one of %q, %q or %q", path, TextFormatPlain, TextFormatWiki, TextFormatADF))
+		}
+		if rc.MaxIssuesPerWindow < 0 || rc.StormWindow < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s: maxissuesperwindow and stormwindow must not be negative", path))
+		}
+		if rc.Parallelism < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s.parallelism: must not be negative", path))
+		}
+		if rc.NotifyTimeout < 0 {
+			errs = multierr.Append(errs, fmt.Errorf("%s.notifytimeout: must not be negative", path))
+		}
+	}
+	if tmpl != nil {
+		errs = multierr.Append(errs, tmpl.Compile(c.Receivers))
+		c.tmpl = tmpl
+	}
+	return errs
+}
+
 func (c *Config) String() string {
 	configLock.RLock()
 	defer configLock.RUnlock()
@@ -95,12 +653,33 @@ func (c *Config) ReceiverByName(name string) *ReceiverConfig {
 	return nil
 }
 
+// APIByName loops the API list and returns the first instance with that name
+func (c *Config) APIByName(name string) *APIConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	for _, a := range c.APIs {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// APIName returns the name of the JIRA endpoint used by the receiver.
+func (rc *ReceiverConfig) APIName() string {
+	if rc.API == "" {
+		return DefaultAPI
+	}
+	return rc.API
+}
+
 func checkOverflow(m map[string]interface{}, ctx string) error {
 	if len(m) > 0 {
 		var keys []string
 		for k := range m {
 			keys = append(keys, k)
 		}
+		sort.Strings(keys)
 		return fmt.Errorf("unknown fields in %s: %s", ctx, strings.Join(keys, ", "))
 	}
 	return nil
@@ -0,0 +1,97 @@
+package jiralert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/multierr"
+)
+
+// writeConfig writes the configuration file and an empty template file in a new directory, returned.
+func writeConfig(t *testing.T, config string) string {
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "jiralert.tmpl")
+	if err := ioutil.WriteFile(tmplFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	config = strings.Replace(config, "TEMPLATE", tmplFile, -1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "jiralert.yml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReadConfigurationSecretErrors(t *testing.T) {
+	os.Setenv("JIRALERT_TEST_EMPTY", "")
+	defer os.Unsetenv("JIRALERT_TEST_EMPTY")
+	dir := writeConfig(t, `
+auth:
+  webhook:
+    bearertoken: '${JIRALERT_TEST_UNSET_WEBHOOK}'
+apis:
+  - name: default
+    url: https://jira.example.com${JIRALERT_TEST_EMPTY}
+    user: jiralert
+    passwordfile: /nonexistent/password
+  - name: cloud
+    url: https://example.atlassian.net
+    token: '${JIRALERT_TEST_UNSET_TOKEN}'
+    auth: bearer
+receivers:
+  - name: test
+    project: PROJ
+    issuetype: Bug
+    reopenstate: Reopen Issue
+template: TEMPLATE
+`)
+	err := (&Config{}).ReadConfiguration(dir)
+	want := []string{
+		`JIRA endpoint "default": cannot read password file`,
+		`JIRA endpoint "cloud": token: environment variable JIRALERT_TEST_UNSET_TOKEN not set`,
+		`auth.webhook: bearertoken: environment variable JIRALERT_TEST_UNSET_WEBHOOK not set`,
+		`receivers[0].summary: missing required field`,
+	}
+	errs := multierr.Errors(err)
+	if len(errs) != len(want) {
+		t.Fatalf("got errors %v, want %d", errs, len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Errorf("error %d is %q, want %q", i, errs[i], prefix)
+		}
+	}
+}
+
+func TestReadConfigurationWithoutSecrets(t *testing.T) {
+	dir := writeConfig(t, `
+auth:
+  webhook:
+    username: alertmanager
+    passwordfile: /nonexistent/password
+apis:
+  - name: default
+    url: https://jira.example.com
+    user: jiralert
+    password: '${JIRALERT_TEST_UNSET_PASSWORD}'
+receivers:
+  - name: test
+    project: PROJ
+    issuetype: Bug
+    summary: '{{ .Status }}'
+    reopenstate: Reopen Issue
+template: TEMPLATE
+`)
+	config := &Config{}
+	if err := config.ReadConfigurationWithoutSecrets(dir); err != nil {
+		t.Fatal(err)
+	}
+	if config.Templates() == nil {
+		t.Error("the templates were not compiled")
+	}
+	if err := (&Config{}).ReadConfiguration(dir); len(multierr.Errors(err)) != 2 {
+		t.Errorf("got errors %v, want the password file and the environment variable reported", err)
+	}
+}
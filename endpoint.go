@@ -0,0 +1,155 @@
+package jiralert
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// Endpoint is a JIRA instance, with the client used by all the receivers referencing it.
+type Endpoint struct {
+	conf      *APIConfig
+	client    *jira.Client
+	limiter   *limitTransport
+	transport *http.Transport
+	files     string // digest of the files read by NewEndpoint
+
+	accounts   sync.Map // JIRA Cloud account IDs by user name
+	createMeta sync.Map // *jira.MetaIssueType by project key and issue type name
+}
+
+// DefaultTimeout is the JIRA request timeout used when the endpoint's HTTPConfig does not set one.
+const DefaultTimeout = 30 * time.Second
+
+// NewEndpoint creates the JIRA client for the API configuration.
+func NewEndpoint(a *APIConfig) (*Endpoint, error) {
+	files, err := endpointFiles(a)
+	if err != nil {
+		return nil, fmt.Errorf("JIRA endpoint %q: %s", a.Name, err)
+	}
+	httpClient, transport, err := newHTTPClient(a)
+	if err != nil {
+		return nil, err
+	}
+	limiter := newLimitTransport(a, httpClient.Transport)
+	httpClient.Transport = limiter
+	client, err := jira.NewClient(httpClient, a.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Endpoint{conf: a, client: client, limiter: limiter, transport: transport, files: files}, nil
+}
+
+// endpointFiles returns a digest of the content of the CA, client certificate and private key files of the API
+// configuration.
+func endpointFiles(a *APIConfig) (string, error) {
+	h := sha256.New()
+	for _, file := range []string{a.HTTPConfig.CAFile, a.HTTPConfig.CertFile, a.HTTPConfig.KeyFile, a.PrivateKeyFile} {
+		if file == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%x\x00", file, sha256.Sum256(b))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newHTTPClient builds the HTTP client of the endpoint from its HTTPConfig, authenticating requests as configured. It
+// also returns the underlying transport, holding the connections.
+func newHTTPClient(a *APIConfig) (*http.Client, *http.Transport, error) {
+	hc := &a.HTTPConfig
+	tlsConfig := &tls.Config{InsecureSkipVerify: hc.InsecureSkipVerify}
+	if hc.CAFile != "" {
+		pem, err := ioutil.ReadFile(hc.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("JIRA endpoint %q: cannot read CA file: %s", a.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("JIRA endpoint %q: no certificate found in CA file %s", a.Name, hc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if hc.CertFile != "" || hc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(hc.CertFile, hc.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("JIRA endpoint %q: cannot load client certificate: %s", a.Name, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if hc.ProxyURL != "" {
+		proxyURL, err := url.Parse(hc.ProxyURL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("JIRA endpoint %q: invalid proxy URL: %s", a.Name, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	auth, err := newAuthTransport(a, transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Transport: auth, Timeout: timeout}, transport, nil
+}
+
+// Name returns the name of the endpoint, as referenced by the receivers' API field.
+func (e *Endpoint) Name() string {
+	return e.conf.Name
+}
+
+// CircuitOpen returns whether the circuit breaker of the endpoint is open: JIRA keeps failing and requests are
+// rejected without being sent.
+func (e *Endpoint) CircuitOpen() bool {
+	return e.limiter.CircuitOpen()
+}
+
+// NewEndpoints creates one Endpoint per API configuration, indexed by name. The HTTP client, connections, rate limit
+// and circuit breaker of the previous endpoint of the same name are kept if its configuration and files are unchanged;
+// the caches of the endpoint are not, they last as long as the configuration.
+func NewEndpoints(apis []*APIConfig, previous map[string]*Endpoint) (map[string]*Endpoint, error) {
+	endpoints := make(map[string]*Endpoint, len(apis))
+	for _, a := range apis {
+		if old := previous[a.Name]; old != nil && *old.conf == *a {
+			if files, err := endpointFiles(a); err == nil && files == old.files {
+				endpoints[a.Name] = &Endpoint{conf: a, client: old.client, limiter: old.limiter, transport: old.transport, files: files}
+				continue
+			}
+		}
+		e, err := NewEndpoint(a)
+		if err != nil {
+			return nil, err
+		}
+		endpoints[a.Name] = e
+	}
+	return endpoints, nil
+}
+
+// CloseDroppedEndpoints closes the idle connections of the previous endpoints that NewEndpoints did not keep in
+// current. Requests still running on them are not interrupted.
+func CloseDroppedEndpoints(previous, current map[string]*Endpoint) {
+	for name, old := range previous {
+		if e := current[name]; e == nil || e.transport != old.transport {
+			old.transport.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package jiralert
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewEndpointsReuse(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte(testCA), 0644); err != nil {
+		t.Fatal(err)
+	}
+	apis := func() []*APIConfig {
+		return []*APIConfig{
+			{Name: "a", URL: "https://a.example.com", User: "jiralert", Password: "secret"},
+			{Name: "b", URL: "https://b.example.com", HTTPConfig: HTTPClientConfig{CAFile: caFile}},
+			{Name: "c", URL: "https://c.example.com"},
+		}
+	}
+	previous, err := NewEndpoints(apis(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := apis()
+	changed[0].Password = "rotated"
+	current, err := NewEndpoints(changed[:2], previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current["a"].transport == previous["a"].transport {
+		t.Error("endpoint a was kept, its password changed")
+	}
+	if current["b"].transport != previous["b"].transport || current["b"].limiter != previous["b"].limiter {
+		t.Error("endpoint b was not kept, it is unchanged")
+	}
+	if current["b"].conf != changed[1] {
+		t.Error("endpoint b kept the previous configuration")
+	}
+
+	// A changed file is read again.
+	if err := ioutil.WriteFile(caFile, []byte(testCA+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := NewEndpoints(apis()[1:2], current); err != nil {
+		t.Fatal(err)
+	} else if next["b"].transport == current["b"].transport {
+		t.Error("endpoint b was kept, its CA file changed")
+	}
+
+	CloseDroppedEndpoints(previous, current)
+}
+
+// testCA is a self-signed certificate.
+const testCA = `-----BEGIN CERTIFICATE-----
+MIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw
+DgYDVQQKEwdBY21lIENvMB4XDTE3MTAyMDE5NDMwNloXDTE4MTAyMDE5NDMwNlow
+EjEQMA4GA1UEChMHQWNtZSBDbzBZMBMGByqGSM49AgEGCCqGSM49AwEHA0IABD0d
+7VNhbWvZLWPuj/RtHFjvtJBEwOkhbN/BnnE8rnZR8+sbwnc/KhCk3FhnpHZnQz7B
+5aETbbIgmuvewdjvSBSjYzBhMA4GA1UdDwEB/wQEAwICpDATBgNVHSUEDDAKBggr
+BgEFBQcDATAPBgNVHRMBAf8EBTADAQH/MCkGA1UdEQQiMCCCDmxvY2FsaG9zdDo1
+NDUzgg4xMjcuMC4wLjE6NTQ1MzAKBggqhkjOPQQDAgNIADBFAiEA2zpJEPQyz6/l
+Wf86aX6PepsntZv2GYlA5UpabfT2EZICICpJ5h/iI+i341gBmLiAFQOyTDT+/wQc
+6MF9+Yw1Yy0t
+-----END CERTIFICATE-----`
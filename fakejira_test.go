@@ -0,0 +1,346 @@
+package jiralert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tixu/jiralert/alertmanager"
+)
+
+func TestMain(m *testing.M) {
+	log.SetLevel(log.ErrorLevel)
+	os.Exit(m.Run())
+}
+
+// fakeJira is a JIRA REST API server keeping its issues in memory. It serves the requests JIRAlert sends: issue
+// search, get, create, edit, comment and transitions, and the create metadata of a single project and issue type.
+type fakeJira struct {
+	*httptest.Server
+	t testing.TB
+
+	mu       sync.Mutex
+	issues   []*fakeIssue
+	requests []string // "METHOD path" of the requests served
+	// fields are the fields of the create metadata, by ID
+	fields map[string]interface{}
+	// failCreates is the number of issue creations answered with 500 Internal Server Error
+	failCreates int
+	// handler, if set, serves the requests before the fake does, returning whether it did
+	handler func(w http.ResponseWriter, req *http.Request) bool
+}
+
+// fakeIssue is an issue of the fakeJira.
+type fakeIssue struct {
+	ID, Key    string
+	Fields     map[string]interface{}
+	Done       bool
+	Resolution string
+	Comments   []interface{}
+}
+
+const (
+	fakeProject   = "PROJ"
+	fakeIssueType = "Bug"
+)
+
+var (
+	issuePathRe = regexp.MustCompile(`^/rest/api/\d/issue/([^/]+)(/comment|/transitions)?$`)
+	jqlLabelRe  = regexp.MustCompile(`labels=("(?:[^"\\]|\\.)*")`)
+)
+
+func newFakeJira(t testing.TB) *fakeJira {
+	f := &fakeJira{t: t, fields: map[string]interface{}{
+		"summary":     map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+		"description": map[string]interface{}{"schema": map[string]interface{}{"type": "string"}},
+	}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeJira) serve(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+	if f.handler != nil && f.handler(w, req) {
+		return
+	}
+
+	var body map[string]interface{}
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	path := req.URL.Path
+	switch {
+	case req.Method == "GET" && (strings.HasSuffix(path, "/search") || strings.HasSuffix(path, "/search/jql")):
+		var issues []interface{}
+		if m := jqlLabelRe.FindStringSubmatch(req.URL.Query().Get("jql")); m != nil {
+			label, _ := strconv.Unquote(m[1])
+			for _, issue := range f.issues {
+				for _, l := range issue.Fields["labels"].([]interface{}) {
+					if l == label {
+						issues = append(issues, issue.json())
+					}
+				}
+			}
+		}
+		writeJSON(w, map[string]interface{}{"issues": issues, "isLast": true})
+
+	case req.Method == "GET" && strings.HasSuffix(path, "/issue/createmeta"):
+		writeJSON(w, map[string]interface{}{"projects": []interface{}{map[string]interface{}{
+			"key":        fakeProject,
+			"issuetypes": []interface{}{map[string]interface{}{"name": fakeIssueType, "fields": f.fields}},
+		}}})
+
+	case req.Method == "POST" && strings.HasSuffix(path, "/issue/"):
+		if f.failCreates > 0 {
+			f.failCreates--
+			http.Error(w, "failing", http.StatusInternalServerError)
+			return
+		}
+		fields, _ := body["fields"].(map[string]interface{})
+		if fields["labels"] == nil {
+			fields["labels"] = []interface{}{}
+		}
+		issue := &fakeIssue{ID: strconv.Itoa(10000 + len(f.issues)), Key: fmt.Sprintf("%s-%d", fakeProject, len(f.issues)+1), Fields: fields}
+		f.issues = append(f.issues, issue)
+		writeJSON(w, map[string]interface{}{"id": issue.ID, "key": issue.Key})
+
+	case issuePathRe.MatchString(path):
+		m := issuePathRe.FindStringSubmatch(path)
+		issue := f.issue(m[1])
+		if issue == nil {
+			http.Error(w, "Issue does not exist", http.StatusNotFound)
+			return
+		}
+		switch req.Method + " " + m[2] {
+		case "GET ":
+			writeJSON(w, issue.json())
+		case "PUT ":
+			for id, value := range body["fields"].(map[string]interface{}) {
+				issue.Fields[id] = value
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case "POST /comment":
+			issue.Comments = append(issue.Comments, body["body"])
+			writeJSON(w, map[string]interface{}{"id": strconv.Itoa(len(issue.Comments))})
+		case "GET /transitions":
+			writeJSON(w, map[string]interface{}{"transitions": []interface{}{
+				map[string]interface{}{"id": "1", "name": "Resolve Issue"},
+				map[string]interface{}{"id": "2", "name": "Reopen Issue"},
+			}})
+		case "POST /transitions":
+			switch body["transition"].(map[string]interface{})["id"] {
+			case "1":
+				issue.Done = true
+				if fields, ok := body["fields"].(map[string]interface{}); ok {
+					issue.Resolution = fields["resolution"].(map[string]interface{})["name"].(string)
+				}
+			case "2":
+				issue.Done, issue.Resolution = false, ""
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+		}
+
+	default:
+		f.t.Errorf("unexpected request %s %s", req.Method, req.URL)
+		http.Error(w, "unexpected request", http.StatusNotFound)
+	}
+}
+
+// issue returns the issue of the given ID or key.
+func (f *fakeJira) issue(id string) *fakeIssue {
+	for _, issue := range f.issues {
+		if issue.ID == id || issue.Key == id {
+			return issue
+		}
+	}
+	return nil
+}
+
+// Issues returns a copy of the issues of the fake.
+func (f *fakeJira) Issues() []fakeIssue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issues := make([]fakeIssue, len(f.issues))
+	for i, issue := range f.issues {
+		issues[i] = *issue
+	}
+	return issues
+}
+
+// Requests returns the number of requests served whose "METHOD path" contains s.
+func (f *fakeJira) Requests(s string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if strings.Contains(r, s) {
+			n++
+		}
+	}
+	return n
+}
+
+// json returns the issue as returned by the JIRA REST API.
+func (i *fakeIssue) json() map[string]interface{} {
+	fields := map[string]interface{}{}
+	for id, value := range i.Fields {
+		fields[id] = value
+	}
+	fields["status"] = map[string]interface{}{"name": "Open", "statusCategory": map[string]interface{}{"key": "new"}}
+	if i.Done {
+		fields["status"] = map[string]interface{}{"name": "Resolved", "statusCategory": map[string]interface{}{"key": "done"}}
+	}
+	if i.Resolution != "" {
+		fields["resolution"] = map[string]interface{}{"name": i.Resolution}
+	}
+	return map[string]interface{}{"id": i.ID, "key": i.Key, "fields": fields}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// memStateStore is a StateStore kept in memory.
+type memStateStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func newMemStateStore() *memStateStore {
+	return &memStateStore{values: map[string][]byte{}}
+}
+
+func (s *memStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key], nil
+}
+
+func (s *memStateStore) Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, err := fn(s.values[key])
+	if err != nil {
+		return err
+	}
+	s.values[key] = value
+	return nil
+}
+
+// testTemplate writes the template file and loads it.
+func testTemplate(t testing.TB, text string) *Template {
+	path := filepath.Join(t.TempDir(), "jiralert.tmpl")
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := LoadTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+// testReceiver returns a receiver of the fake JIRA, authenticating with a user and password, with the required
+// fields of conf set if empty.
+func testReceiver(t testing.TB, f *fakeJira, conf *ReceiverConfig) *Receiver {
+	return testAPIReceiver(t, &APIConfig{Name: DefaultAPI, URL: f.URL, User: "jiralert", Password: "secret"}, conf)
+}
+
+// testAPIReceiver returns a receiver of the JIRA endpoint, with the required fields of conf set if empty.
+func testAPIReceiver(t testing.TB, api *APIConfig, conf *ReceiverConfig) *Receiver {
+	if conf.Name == "" {
+		conf.Name = "test"
+	}
+	if conf.Project == "" {
+		conf.Project = fakeProject
+	}
+	if conf.IssueType == "" {
+		conf.IssueType = fakeIssueType
+	}
+	if conf.Summary == "" {
+		conf.Summary = `{{ template "jira.summary" . }}`
+	}
+	if conf.ReopenState == "" {
+		conf.ReopenState = "Reopen Issue"
+	}
+	tmpl := testTemplate(t, `{{ define "jira.summary" }}Alerts {{ .Status }}{{ end }}`)
+	if err := tmpl.Compile([]*ReceiverConfig{conf}); err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewEndpoint(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReceiver(context.Background(), e, conf, tmpl, NewMemoryStore(), newMemStateStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testAlert returns a firing alert with the given labels, as name, value pairs.
+func testAlert(labels ...string) alertmanager.Alert {
+	alert := alertmanager.Alert{Status: alertmanager.AlertFiring, Labels: alertmanager.KV{}, Annotations: alertmanager.KV{}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		alert.Labels[labels[i]] = labels[i+1]
+	}
+	alert.Fingerprint = fmt.Sprintf("%x", toIssueLabel(alert.Labels))
+	return alert
+}
+
+// testData returns a notification of the alerts, grouped by the given label names.
+func testData(groupBy []string, alerts ...alertmanager.Alert) *alertmanager.Data {
+	data := &alertmanager.Data{
+		Version:     "4",
+		Receiver:    "test",
+		Status:      alertmanager.AlertFiring,
+		Alerts:      alerts,
+		GroupLabels: alertmanager.KV{},
+		ExternalURL: "http://alertmanager",
+	}
+	for _, name := range groupBy {
+		data.GroupLabels[name] = alerts[0].Labels[name]
+	}
+	data.GroupKey = "{}:" + toIssueLabel(data.GroupLabels)
+	data.CommonLabels = commonKV(alerts, func(a alertmanager.Alert) alertmanager.KV { return a.Labels })
+	data.CommonAnnotations = commonKV(alerts, func(a alertmanager.Alert) alertmanager.KV { return a.Annotations })
+	return data
+}
+
+// notify sends the notification to the receiver and fails the test unless all its issues succeed.
+func notify(t testing.TB, r *Receiver, data *alertmanager.Data) map[string]StatusNotify {
+	t.Helper()
+	statuses, err := r.Notify(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, status := range statuses {
+		if status.Status != http.StatusOK {
+			t.Fatalf("issue %s: status %d: %v", label, status.Status, status.Err)
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,158 @@
+package jiralert
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/andygrunwald/go-jira"
+	log "github.com/sirupsen/logrus"
+)
+
+// Names of the standard issue fields that may be listed in a receiver's UpdateFields, next to the IDs of its Fields.
+const (
+	FieldSummary     = "summary"
+	FieldDescription = "description"
+	FieldPriority    = "priority"
+)
+
+// renderUpdateFields renders the receiver's UpdateFields for the alert group, in the form expected by the JIRA edit
+// issue API.
+func (r *Receiver) renderUpdateFields(group *alertGroup) (map[string]interface{}, error) {
+	rd := &renderer{tmpl: r.tmpl, data: group.data}
+	fields := make(map[string]interface{}, len(r.conf.UpdateFields))
+	for _, name := range r.conf.UpdateFields {
+		switch name {
+		case FieldSummary:
+			fields[name] = rd.render("summary", r.conf.Summary)
+		case FieldDescription:
+			fields[name] = r.formatText(rd.render("description", r.conf.Description) + group.note)
+		case FieldPriority:
+			fields[name] = map[string]interface{}{"name": rd.render("priority", r.conf.Priority)}
+		default:
+			fields[name] = mapStrings(r.conf.Fields[name], "fields."+name, rd.render)
+		}
+	}
+	return fields, rd.err
+}
+
+// updateFields edits the UpdateFields of the existing issue whose rendered value changed since JIRAlert last set
+// them. The other fields, and the fields whose rendered value did not change, are left alone so that edits made by
+// humans are kept. When JIRAlert never set a field, e.g. on issues created before it was listed in UpdateFields, the
+// rendered value is compared with the current one instead.
+func (r *Receiver) updateFields(ctx context.Context, issue *jira.Issue, group *alertGroup) error {
+	if len(r.conf.UpdateFields) == 0 {
+		return nil
+	}
+	fields, err := r.renderUpdateFields(group)
+	if err != nil {
+		return err
+	}
+	last := r.fieldHashes(ctx, issue)
+	hashes := fieldHashes(fields)
+	changed := map[string]interface{}{}
+	for name, value := range fields {
+		if lastHash, ok := last[name]; ok {
+			if lastHash != hashes[name] {
+				changed[name] = value
+			}
+		} else if current, known := currentField(issue, name); !known || hashValue(current) != hashes[name] {
+			changed[name] = value
+		}
+	}
+	if len(changed) == 0 {
+		log.Infof("Fields of issue %s unchanged, not updating", issue.Key)
+		return nil
+	}
+
+	if r.apiVersion() == APIVersion3 && hasCustomFields(changed) {
+		meta, err := r.issueTypeMeta(ctx, issue.Fields.Project.Key, issue.Fields.Type.Name)
+		if err != nil {
+			return err
+		}
+		if err := r.resolveUsers(ctx, meta, changed); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Updating fields of issue %s: %+v", issue.Key, changed)
+	resp, err := r.editIssue(ctx, issue.ID, map[string]interface{}{"fields": changed})
+	if err != nil {
+		return handleJiraError("Issue.Update", resp, err)
+	}
+	r.saveFieldHashes(ctx, issue, hashes)
+	return nil
+}
+
+// saveFieldHashes records the hashes of the UpdateFields values set on the issue.
+func (r *Receiver) saveFieldHashes(ctx context.Context, issue *jira.Issue, hashes map[string]string) {
+	value, err := json.Marshal(hashes)
+	if err == nil {
+		err = r.state.Update(ctx, "fields/"+issue.ID, func([]byte) ([]byte, error) { return value, nil })
+	}
+	if err != nil {
+		log.Warnf("got an error while updating the state store %s", err)
+	}
+}
+
+// fieldHashes returns the hashes of the UpdateFields values last set on the issue.
+func (r *Receiver) fieldHashes(ctx context.Context, issue *jira.Issue) map[string]string {
+	hashes := map[string]string{}
+	value, err := r.state.Get(ctx, "fields/"+issue.ID)
+	if err != nil {
+		log.Warnf("got an error while reading the state store %s", err)
+		return hashes
+	}
+	if value != nil {
+		if err := json.Unmarshal(value, &hashes); err != nil {
+			log.Warnf("ignoring invalid field state of issue %s: %s", issue.Key, err)
+		}
+	}
+	return hashes
+}
+
+// currentField returns the current value of a standard field of the issue, in the form renderUpdateFields uses.
+// Custom field values are returned by JIRA in a different form than they are set, they are never known.
+func currentField(issue *jira.Issue, name string) (interface{}, bool) {
+	if issue.Fields == nil {
+		return nil, false
+	}
+	switch name {
+	case FieldSummary:
+		return issue.Fields.Summary, true
+	case FieldDescription:
+		return issue.Fields.Description, true
+	case FieldPriority:
+		if issue.Fields.Priority == nil {
+			return nil, false
+		}
+		return map[string]interface{}{"name": issue.Fields.Priority.Name}, true
+	}
+	return nil, false
+}
+
+// hasCustomFields returns whether fields holds other fields than the standard ones.
+func hasCustomFields(fields map[string]interface{}) bool {
+	for name := range fields {
+		if name != FieldSummary && name != FieldDescription && name != FieldPriority {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldHashes(fields map[string]interface{}) map[string]string {
+	hashes := make(map[string]string, len(fields))
+	for name, value := range fields {
+		hashes[name] = hashValue(value)
+	}
+	return hashes
+}
+
+// hashValue returns a hash of the JSON encoding of value, maps are encoded with sorted keys.
+func hashValue(value interface{}) string {
+	b, _ := json.Marshal(value)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
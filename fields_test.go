@@ -0,0 +1,62 @@
+package jiralert
+
+import (
+	"testing"
+
+	"github.com/tixu/jiralert/alertmanager"
+)
+
+func TestUpdateFieldsKeepsHumanEdits(t *testing.T) {
+	f := newFakeJira(t)
+	f.fields["customfield_10001"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	r := testReceiver(t, f, &ReceiverConfig{
+		GroupBy:      []string{GroupByAlert},
+		Summary:      "{{ .Annotations.summary }}",
+		Fields:       map[string]interface{}{"customfield_10001": "{{ .Annotations.summary }}"},
+		UpdateFields: []string{FieldSummary, "customfield_10001"},
+	})
+	annotated := func(summary string) *alertmanager.Data {
+		alert := testAlert("alertname", "A")
+		alert.Annotations = alertmanager.KV{"summary": summary}
+		return testData(nil, alert)
+	}
+	fields := func() (interface{}, interface{}) {
+		issues := f.Issues()
+		if len(issues) != 1 {
+			t.Fatalf("got %d issues, want 1", len(issues))
+		}
+		return issues[0].Fields["summary"], issues[0].Fields["customfield_10001"]
+	}
+
+	notify(t, r, annotated("disk 80% full"))
+	f.mu.Lock()
+	f.issues[0].Fields["summary"] = "Disk full on db1, see INC-42"
+	f.issues[0].Fields["customfield_10001"] = "investigating"
+	f.mu.Unlock()
+
+	// The rendered values did not change, the edits are kept.
+	notify(t, r, annotated("disk 80% full"))
+	if summary, custom := fields(); summary != "Disk full on db1, see INC-42" || custom != "investigating" {
+		t.Errorf("got summary %q and customfield_10001 %q, want the human edits kept", summary, custom)
+	}
+	if n := f.Requests("PUT "); n != 0 {
+		t.Errorf("got %d issue updates, want none", n)
+	}
+
+	notify(t, r, annotated("disk 90% full"))
+	if summary, custom := fields(); summary != "disk 90% full" || custom != "disk 90% full" {
+		t.Errorf("got summary %q and customfield_10001 %q, want the new rendered values", summary, custom)
+	}
+
+	// The edit of an updated field is kept until its rendered value changes again.
+	f.mu.Lock()
+	f.issues[0].Fields["summary"] = "Disk full on db1"
+	f.mu.Unlock()
+	notify(t, r, annotated("disk 90% full"))
+	if summary, _ := fields(); summary != "Disk full on db1" {
+		t.Errorf("got summary %q, want the human edit kept", summary)
+	}
+	if n := f.Requests("PUT "); n != 1 {
+		t.Errorf("got %d issue updates, want 1", n)
+	}
+}
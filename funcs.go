@@ -0,0 +1,202 @@
+package jiralert
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	tmplhtml "html/template"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// funcs are the functions available to the templates, the same as Alertmanager's so that Alertmanager templates can
+// be reused unchanged. Functions taking a regular expression or converting values return an error, failing the
+// template execution, rather than panicking.
+var funcs = template.FuncMap{
+	"toUpper":   strings.ToUpper,
+	"toLower":   strings.ToLower,
+	"title":     strings.Title,
+	"trimSpace": strings.TrimSpace,
+	// join is equal to strings.Join but inverts the argument order
+	// for easier pipelining in templates.
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	"match": func(pattern, text string) (bool, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(text), nil
+	},
+	"reReplaceAll": func(pattern, repl, text string) (string, error) {
+		re, err := compileRegexp(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(text, repl), nil
+	},
+	"safeHtml": func(text string) tmplhtml.HTML {
+		return tmplhtml.HTML(text)
+	},
+	"safeUrl": func(text string) tmplhtml.URL {
+		return tmplhtml.URL(text)
+	},
+	"urlUnescape": url.QueryUnescape,
+	"stringSlice": func(s ...string) []string {
+		return s
+	},
+	"toJson": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"tz": func(name string, t time.Time) (time.Time, error) {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.In(loc), nil
+	},
+	"since":            time.Since,
+	"humanize":         humanize,
+	"humanizeDuration": humanizeDuration,
+	"toJiraWiki":       ToJiraWiki,
+}
+
+// maxRegexps bounds the number of compiled regular expressions cached by compileRegexp, patterns built from alert data
+// would otherwise grow the cache without limit.
+const maxRegexps = 256
+
+var (
+	regexpsMu sync.Mutex
+	regexps   = map[string]*list.Element{} // elements of regexpsLRU, by pattern
+	// regexpsLRU lists the cached *regexp.Regexp, the most recently used first.
+	regexpsLRU = list.New()
+)
+
+// compileRegexp compiles the pattern, caching the result since templates usually run the same patterns over and over.
+// The least recently used patterns are evicted past maxRegexps.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpsMu.Lock()
+	defer regexpsMu.Unlock()
+	if e, ok := regexps[pattern]; ok {
+		regexpsLRU.MoveToFront(e)
+		return e.Value.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexps[pattern] = regexpsLRU.PushFront(re)
+	if regexpsLRU.Len() > maxRegexps {
+		oldest := regexpsLRU.Remove(regexpsLRU.Back()).(*regexp.Regexp)
+		delete(regexps, oldest.String())
+	}
+	return re, nil
+}
+
+// toFloat64 converts numbers, numeric strings and durations (as seconds) to float64.
+func toFloat64(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case time.Duration:
+		return v.Seconds(), nil
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, fmt.Errorf("cannot convert %v (%T) to a number", v, v)
+	}
+}
+
+// humanize formats a number with a metric prefix, e.g. 1234 as "1.234k", like Prometheus' humanize function.
+func humanize(i interface{}) (string, error) {
+	v, err := toFloat64(i)
+	if err != nil {
+		return "", err
+	}
+	if v == 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	prefix := ""
+	if math.Abs(v) >= 1 {
+		for _, p := range []string{"k", "M", "G", "T", "P", "E", "Z", "Y"} {
+			if math.Abs(v) < 1000 {
+				break
+			}
+			prefix = p
+			v /= 1000
+		}
+		return fmt.Sprintf("%.4g%s", v, prefix), nil
+	}
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%s", v, prefix), nil
+}
+
+// humanizeDuration formats a number of seconds, or a duration, e.g. 93784 as "1d 2h 3m 4s", like Prometheus'
+// humanizeDuration function.
+func humanizeDuration(i interface{}) (string, error) {
+	v, err := toFloat64(i)
+	if err != nil {
+		return "", err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Sprintf("%.4g", v), nil
+	}
+	if v == 0 {
+		return "0s", nil
+	}
+	if math.Abs(v) >= 1 {
+		sign := ""
+		if v < 0 {
+			sign = "-"
+			v = -v
+		}
+		duration := int64(v)
+		seconds := duration % 60
+		minutes := (duration / 60) % 60
+		hours := (duration / 60 / 60) % 24
+		days := duration / 60 / 60 / 24
+		switch {
+		case days != 0:
+			return fmt.Sprintf("%s%dd %dh %dm %ds", sign, days, hours, minutes, seconds), nil
+		case hours != 0:
+			return fmt.Sprintf("%s%dh %dm %ds", sign, hours, minutes, seconds), nil
+		case minutes != 0:
+			return fmt.Sprintf("%s%dm %ds", sign, minutes, seconds), nil
+		}
+		return fmt.Sprintf("%s%.4gs", sign, v), nil
+	}
+	prefix := ""
+	for _, p := range []string{"m", "u", "n", "p", "f", "a", "z", "y"} {
+		if math.Abs(v) >= 1 {
+			break
+		}
+		prefix = p
+		v *= 1000
+	}
+	return fmt.Sprintf("%.4g%ss", v, prefix), nil
+}
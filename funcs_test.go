@@ -0,0 +1,31 @@
+package jiralert
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompileRegexpBounded(t *testing.T) {
+	first, err := compileRegexp("^first$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*maxRegexps; i++ {
+		if _, err := compileRegexp(fmt.Sprintf("^%d$", i)); err != nil {
+			t.Fatal(err)
+		}
+		// Kept as the most recently used.
+		if re, _ := compileRegexp("^first$"); re != first {
+			t.Fatal("the most recently used pattern was evicted")
+		}
+	}
+	regexpsMu.Lock()
+	n, lru := len(regexps), regexpsLRU.Len()
+	regexpsMu.Unlock()
+	if n != maxRegexps || lru != maxRegexps {
+		t.Errorf("cache holds %d patterns (%d listed), want %d", n, lru, maxRegexps)
+	}
+	if _, err := compileRegexp("("); err == nil {
+		t.Error("invalid pattern compiled")
+	}
+}
@@ -1,5 +1,7 @@
 module github.com/tixu/jiralert
 
+go 1.15
+
 require (
 	github.com/andygrunwald/go-jira v0.0.0-20171028181900-8a3af9ba5a69
 	github.com/beorn7/perks v0.0.0-20160804104726-4c0e84591b9a
@@ -7,7 +9,7 @@ require (
 	github.com/coreos/bbolt v1.3.0 // indirect
 	github.com/fatih/structs v0.0.0-20171020064819-f5faa72e7309
 	github.com/free/jiralert v0.0.0-20180519110126-e6a3a85bd981 // indirect
-	github.com/fsnotify/fsnotify v1.4.7 // indirect
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
 	github.com/golang/protobuf v0.0.0-20171021043952-1643683e1b54
 	github.com/google/go-querystring v0.0.0-20170111101155-53e6ce116135
@@ -15,7 +17,7 @@ require (
 	github.com/magefile/mage v1.4.0 // indirect
 	github.com/magiconair/properties v1.8.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1
-	github.com/mitchellh/mapstructure v1.0.0 // indirect
+	github.com/mitchellh/mapstructure v1.0.0
 	github.com/pelletier/go-toml v1.2.0 // indirect
 	github.com/prometheus/client_golang v0.0.0-20171005112915-5cec1d0429b0
 	github.com/prometheus/client_model v0.0.0-20170216185247-6f3806018612
@@ -31,13 +33,12 @@ require (
 	github.com/spf13/viper v1.1.0
 	github.com/trK54Ylmz/logrus-boltdb-hook v0.0.0-20180811094144-750b83e7a3f5
 	github.com/trivago/tgo v1.0.1
-	go.etcd.io/bbolt v1.3.0
+	go.etcd.io/bbolt v1.3.6
 	go.opencensus.io v0.15.0
 	go.uber.org/atomic v1.3.2 // indirect
 	go.uber.org/multierr v1.1.0
 	golang.org/x/crypto v0.0.0-20180904163835-0709b304e793 // indirect
 	golang.org/x/net v0.0.0-20180906233101-161cd47e91fd // indirect
-	golang.org/x/sys v0.0.0-20180905080454-ebe1bf3edb33 // indirect
 	golang.org/x/text v0.3.0 // indirect
 	google.golang.org/grpc v1.14.0 // indirect
 	gopkg.in/yaml.v2 v2.0.0-20170812160011-eb3733d160e7
@@ -0,0 +1,161 @@
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// The go-jira client does not take a context, the requests below are built with it and bound to a context so that
+// they are aborted as soon as the notification is cancelled or its deadline expires.
+
+// do sends a JIRA REST API request bound to ctx, JSON decoding the response into v unless v is nil.
+func (r *Receiver) do(ctx context.Context, method, apiEndpoint string, body, v interface{}) (*jira.Response, error) {
+	req, err := r.client.NewRequest(method, apiEndpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	return r.client.Do(req.WithContext(ctx), v)
+}
+
+// apiVersion returns the REST API version of the receiver's endpoint.
+func (r *Receiver) apiVersion() int {
+	if r.endpoint == nil {
+		return APIVersion2
+	}
+	return r.endpoint.conf.version()
+}
+
+// api returns the path of the REST API of the receiver's endpoint.
+func (r *Receiver) api() string {
+	return fmt.Sprintf("rest/api/%d", r.apiVersion())
+}
+
+// getIssueByID is the context aware version of Issue.Get. The REST API v3 returns the rich text fields, e.g. the
+// description, as ADF documents which jira.Issue cannot hold, only the given fields are fetched from it.
+func (r *Receiver) getIssueByID(ctx context.Context, id string, fields []string) (*jira.Issue, *jira.Response, error) {
+	apiEndpoint := fmt.Sprintf("%s/issue/%s", r.api(), id)
+	if r.apiVersion() == APIVersion3 {
+		apiEndpoint += "?" + url.Values{"fields": {strings.Join(fields, ",")}}.Encode()
+	}
+	issue := new(jira.Issue)
+	resp, err := r.do(ctx, "GET", apiEndpoint, nil, issue)
+	if err != nil {
+		return nil, resp, err
+	}
+	return issue, resp, nil
+}
+
+// searchIssues is the context aware version of Issue.Search. On the REST API v3 it uses the enhanced JQL search,
+// following the pages until options.MaxResults issues are found.
+func (r *Receiver) searchIssues(ctx context.Context, jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	if r.apiVersion() == APIVersion3 {
+		return r.searchIssuesJQL(ctx, jql, options)
+	}
+	query := url.Values{"jql": {jql}}
+	if options != nil {
+		query.Set("startAt", fmt.Sprint(options.StartAt))
+		query.Set("maxResults", fmt.Sprint(options.MaxResults))
+		if options.Expand != "" {
+			query.Set("expand", options.Expand)
+		}
+		if len(options.Fields) > 0 {
+			query.Set("fields", strings.Join(options.Fields, ","))
+		}
+	}
+	result := struct {
+		Issues []jira.Issue `json:"issues"`
+	}{}
+	resp, err := r.do(ctx, "GET", r.api()+"/search?"+query.Encode(), nil, &result)
+	return result.Issues, resp, err
+}
+
+// searchIssuesJQL searches issues with the enhanced JQL search of the REST API v3, which pages with a cursor.
+func (r *Receiver) searchIssuesJQL(ctx context.Context, jql string, options *jira.SearchOptions) ([]jira.Issue, *jira.Response, error) {
+	query := url.Values{"jql": {jql}}
+	if options != nil {
+		if options.MaxResults > 0 {
+			query.Set("maxResults", fmt.Sprint(options.MaxResults))
+		}
+		if options.Expand != "" {
+			query.Set("expand", options.Expand)
+		}
+		if len(options.Fields) > 0 {
+			query.Set("fields", strings.Join(options.Fields, ","))
+		}
+	}
+	var issues []jira.Issue
+	for {
+		result := struct {
+			Issues        []jira.Issue `json:"issues"`
+			NextPageToken string       `json:"nextPageToken"`
+			IsLast        bool         `json:"isLast"`
+		}{}
+		resp, err := r.do(ctx, "GET", r.api()+"/search/jql?"+query.Encode(), nil, &result)
+		if err != nil {
+			return nil, resp, err
+		}
+		issues = append(issues, result.Issues...)
+		if result.IsLast || result.NextPageToken == "" || (options != nil && options.MaxResults > 0 && len(issues) >= options.MaxResults) {
+			return issues, resp, nil
+		}
+		query.Set("nextPageToken", result.NextPageToken)
+	}
+}
+
+// createIssue is the context aware version of Issue.Create.
+func (r *Receiver) createIssue(ctx context.Context, issue *jira.Issue) (*jira.Issue, *jira.Response, error) {
+	created := new(jira.Issue)
+	resp, err := r.do(ctx, "POST", r.api()+"/issue/", issue, created)
+	if err != nil {
+		return nil, resp, err
+	}
+	return created, resp, nil
+}
+
+// editIssue is the context aware version of Issue.Update, payload sets the edited fields only.
+func (r *Receiver) editIssue(ctx context.Context, issueID string, payload interface{}) (*jira.Response, error) {
+	return r.do(ctx, "PUT", fmt.Sprintf("%s/issue/%s", r.api(), issueID), payload, nil)
+}
+
+// addIssueComment is the context aware version of Issue.AddComment, body is the comment string or ADF document.
+func (r *Receiver) addIssueComment(ctx context.Context, issueID string, body interface{}) (*jira.Response, error) {
+	return r.do(ctx, "POST", fmt.Sprintf("%s/issue/%s/comment", r.api(), issueID), map[string]interface{}{"body": body}, nil)
+}
+
+// getTransitions is the context aware version of Issue.GetTransitions.
+func (r *Receiver) getTransitions(ctx context.Context, issueKey string) ([]jira.Transition, *jira.Response, error) {
+	result := struct {
+		Transitions []jira.Transition `json:"transitions"`
+	}{}
+	resp, err := r.do(ctx, "GET", fmt.Sprintf("%s/issue/%s/transitions?expand=transitions.fields", r.api(), issueKey), nil, &result)
+	return result.Transitions, resp, err
+}
+
+// doTransition is the context aware version of Issue.DoTransitionWithPayload.
+func (r *Receiver) doTransition(ctx context.Context, issueKey string, payload interface{}) (*jira.Response, error) {
+	return r.do(ctx, "POST", fmt.Sprintf("%s/issue/%s/transitions", r.api(), issueKey), payload, nil)
+}
+
+// getCreateMeta is the context aware version of Issue.GetCreateMeta.
+func (r *Receiver) getCreateMeta(ctx context.Context, project string) (*jira.CreateMetaInfo, *jira.Response, error) {
+	meta := new(jira.CreateMetaInfo)
+	resp, err := r.do(ctx, "GET", r.api()+"/issue/createmeta?"+url.Values{
+		"projectKeys": {project},
+		"expand":      {"projects.issuetypes.fields"},
+	}.Encode(), nil, meta)
+	if err != nil {
+		return nil, resp, err
+	}
+	return meta, resp, nil
+}
+
+// searchUsers is the context aware version of User.Find, it returns the JIRA Cloud users matching the query.
+func (r *Receiver) searchUsers(ctx context.Context, query string) ([]cloudUser, *jira.Response, error) {
+	var users []cloudUser
+	resp, err := r.do(ctx, "GET", r.api()+"/user/search?"+url.Values{"query": {query}}.Encode(), nil, &users)
+	return users, resp, err
+}
@@ -0,0 +1,60 @@
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+func TestSearchIssuesJQLPagination(t *testing.T) {
+	f := newFakeJira(t)
+	var tokens []string
+	f.handler = func(w http.ResponseWriter, req *http.Request) bool {
+		if !strings.HasSuffix(req.URL.Path, "/search/jql") {
+			return false
+		}
+		// Pages of two issues, the token is the index of the next issue.
+		token := req.URL.Query().Get("nextPageToken")
+		tokens = append(tokens, token)
+		start, _ := strconv.Atoi(token)
+		var issues []interface{}
+		for i := start; i < start+2 && i < 5; i++ {
+			issues = append(issues, map[string]interface{}{"id": strconv.Itoa(10000 + i), "key": fmt.Sprintf("%s-%d", fakeProject, i+1)})
+		}
+		result := map[string]interface{}{"issues": issues, "isLast": start+2 >= 5}
+		if start+2 < 5 {
+			result["nextPageToken"] = strconv.Itoa(start + 2)
+		}
+		writeJSON(w, result)
+		return true
+	}
+	api := &APIConfig{Name: DefaultAPI, URL: f.URL, APIVersion: APIVersion3}
+	r := testAPIReceiver(t, api, &ReceiverConfig{})
+
+	for _, tc := range []struct {
+		maxResults int
+		want       int
+		tokens     string
+	}{
+		{maxResults: 0, want: 5, tokens: ",2,4"},
+		{maxResults: 3, want: 4, tokens: ",2"},
+		{maxResults: 2, want: 2, tokens: ""},
+	} {
+		tokens = nil
+		issues, _, err := r.searchIssuesJQL(context.Background(), `project="PROJ"`, &jira.SearchOptions{MaxResults: tc.maxResults})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(issues) != tc.want || issues[0].Key != "PROJ-1" || issues[len(issues)-1].Key != fmt.Sprintf("PROJ-%d", tc.want) {
+			t.Errorf("maxresults %d: got %d issues %v, want PROJ-1 to PROJ-%d", tc.maxResults, len(issues), issues, tc.want)
+		}
+		if got := strings.Join(tokens, ","); got != tc.tokens {
+			t.Errorf("maxresults %d: got page tokens %q, want %q", tc.maxResults, got, tc.tokens)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package jiralert
+
+import (
+	"context"
+	"sync"
+)
+
+// keyLock serializes work per key, e.g. per JIRA issue, keeping a lock only for the keys in use.
+type keyLock struct {
+	mu    sync.Mutex
+	locks map[string]*keyLockEntry
+}
+
+type keyLockEntry struct {
+	sem  chan struct{}
+	refs int
+}
+
+func newKeyLock() *keyLock {
+	return &keyLock{locks: map[string]*keyLockEntry{}}
+}
+
+// Lock waits until the key is unlocked and locks it. It returns ctx.Err(), without locking the key, if ctx is done
+// first.
+func (k *keyLock) Lock(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyLockEntry{sem: make(chan struct{}, 1)}
+		k.locks[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	select {
+	case entry.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		k.release(key, entry)
+		return ctx.Err()
+	}
+}
+
+// Unlock unlocks the key, which must be locked.
+func (k *keyLock) Unlock(key string) {
+	k.mu.Lock()
+	entry := k.locks[key]
+	k.mu.Unlock()
+	<-entry.sem
+	k.release(key, entry)
+}
+
+func (k *keyLock) release(key string, entry *keyLockEntry) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	entry.refs--
+	if entry.refs == 0 {
+		delete(k.locks, key)
+	}
+}
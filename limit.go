@@ -0,0 +1,343 @@
+package jiralert
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// DefaultBreakerTimeout is the time an open circuit breaker waits before a trial request, unless the endpoint's
+// CircuitBreaker configuration sets one.
+const DefaultBreakerTimeout = 30 * time.Second
+
+// ErrCircuitOpen is returned for the requests to a JIRA endpoint whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open, JIRA is failing")
+
+// Reasons a JIRA request is throttled, as recorded by MThrottled.
+const (
+	// ThrottleRateLimit is a request delayed by the endpoint's rate limit.
+	ThrottleRateLimit = "ratelimit"
+	// ThrottleRetryAfter is a request delayed or retried after JIRA answered 429 Too Many Requests.
+	ThrottleRetryAfter = "retry_after"
+	// ThrottleCircuitOpen is a request rejected by the open circuit breaker.
+	ThrottleCircuitOpen = "circuit_open"
+)
+
+// Circuit breaker states, as recorded by MBreakerState.
+const (
+	BreakerClosed = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// maxRetryAfter is the number of times a request answered with 429 Too Many Requests is retried.
+const maxRetryAfter = 3
+
+var (
+	// MThrottled is the number of JIRA requests delayed or rejected, tagged by EndpointKey and ReasonKey.
+	MThrottled = stats.Int64("jira/throttled", "The number of JIRA requests throttled", "1")
+	// MBreakerState is the circuit breaker state of a JIRA endpoint, tagged by EndpointKey.
+	MBreakerState = stats.Int64("jira/breaker_state", "The circuit breaker state of a JIRA endpoint", "1")
+
+	// EndpointKey is the name of the JIRA endpoint.
+	EndpointKey, _ = tag.NewKey("endpoint")
+	// ReasonKey is the reason a request is throttled.
+	ReasonKey, _ = tag.NewKey("reason")
+)
+
+// limitTransport protects a JIRA endpoint: it limits the rate of requests, waits as long as JIRA asks to after a
+// 429 Too Many Requests and stops sending requests while JIRA keeps failing.
+type limitTransport struct {
+	name    string
+	base    http.RoundTripper
+	bucket  *tokenBucket
+	breaker *breaker
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+func newLimitTransport(a *APIConfig, base http.RoundTripper) *limitTransport {
+	t := &limitTransport{name: a.Name, base: base}
+	if a.RateLimit.Rate > 0 {
+		t.bucket = newTokenBucket(a.RateLimit.Rate, a.RateLimit.Burst)
+	}
+	if a.CircuitBreaker.Failures > 0 {
+		timeout := a.CircuitBreaker.Timeout
+		if timeout <= 0 {
+			timeout = DefaultBreakerTimeout
+		}
+		t.breaker = &breaker{failures: a.CircuitBreaker.Failures, timeout: timeout, onChange: t.breakerChanged}
+	}
+	t.recordState(BreakerClosed)
+	return t
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if !t.breaker.allow() {
+			t.recordThrottled(ctx, ThrottleCircuitOpen)
+			return nil, ErrCircuitOpen
+		}
+		if err := t.wait(ctx); err != nil {
+			t.breaker.release()
+			return nil, err
+		}
+		resp, err := t.base.RoundTrip(req)
+		if ctx.Err() != nil {
+			t.breaker.release()
+		} else {
+			t.breaker.record(err != nil || resp.StatusCode >= 500)
+		}
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		delay := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		log.Warnf("JIRA endpoint %q is rate limiting requests, pausing for %s", t.name, delay)
+		t.pause(delay)
+		t.recordThrottled(ctx, ThrottleRetryAfter)
+		if attempt >= maxRetryAfter || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = cloneRequest(req)
+			req.Body = body
+		}
+	}
+}
+
+// wait blocks until the request may be sent according to the rate limit and the last Retry-After, or ctx is done.
+func (t *limitTransport) wait(ctx context.Context) error {
+	now := time.Now()
+	t.mu.Lock()
+	delay, reason := t.pausedUntil.Sub(now), ThrottleRetryAfter
+	t.mu.Unlock()
+	if t.bucket != nil {
+		if d := t.bucket.reserve(now); d > delay {
+			delay, reason = d, ThrottleRateLimit
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	t.recordThrottled(ctx, reason)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		if t.bucket != nil {
+			t.bucket.cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+// pause delays all the requests to the endpoint by d.
+func (t *limitTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// CircuitOpen returns whether the circuit breaker is open, i.e. requests are rejected without being sent.
+func (t *limitTransport) CircuitOpen() bool {
+	return t.breaker.state() == BreakerOpen
+}
+
+func (t *limitTransport) recordThrottled(ctx context.Context, reason string) {
+	ctx, err := tag.New(ctx, tag.Upsert(EndpointKey, t.name), tag.Upsert(ReasonKey, reason))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, MThrottled.M(1))
+}
+
+func (t *limitTransport) breakerChanged(state int) {
+	switch state {
+	case BreakerOpen:
+		log.Warnf("JIRA endpoint %q keeps failing, circuit breaker open for %s", t.name, t.breaker.timeout)
+	case BreakerHalfOpen:
+		log.Infof("JIRA endpoint %q circuit breaker half-open, letting a trial request through", t.name)
+	case BreakerClosed:
+		log.Infof("JIRA endpoint %q recovered, circuit breaker closed", t.name)
+	}
+	t.recordState(state)
+}
+
+func (t *limitTransport) recordState(state int) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(EndpointKey, t.name))
+	if err != nil {
+		return
+	}
+	stats.Record(ctx, MBreakerState.M(int64(state)))
+}
+
+// retryAfter parses the value of a Retry-After header, either a number of seconds or an HTTP date. It defaults to
+// one second.
+func retryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return time.Second
+}
+
+// tokenBucket is a token bucket rate limiter, refilled at rate tokens per second up to burst tokens.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before it is available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// cancel gives back a token reserved by a request that was not sent.
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// breaker is a circuit breaker: it opens after a number of consecutive failures, rejecting all requests until its
+// timeout expires. It is then half-open, a single trial request is let through, closing the breaker again if it
+// succeeds. A nil breaker lets all requests through.
+type breaker struct {
+	failures int
+	timeout  time.Duration
+	onChange func(state int)
+
+	mu       sync.Mutex
+	current  int
+	failed   int
+	openedAt time.Time
+	trial    bool
+}
+
+// allow returns whether a request may be sent.
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	switch b.current {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+// release is called for an allowed request that was not sent.
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// record is called with the outcome of an allowed request.
+func (b *breaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if !failed {
+		b.failed = 0
+		b.setState(BreakerClosed)
+		return
+	}
+	b.failed++
+	if b.current == BreakerHalfOpen || b.failed >= b.failures {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+		// Report the half-open state when the timeout expires rather than on the next request.
+		time.AfterFunc(b.timeout, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire()
+		})
+	}
+}
+
+func (b *breaker) state() int {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	return b.current
+}
+
+// expire moves an open breaker whose timeout expired to half-open. It must be called with b.mu held.
+func (b *breaker) expire() {
+	if b.current == BreakerOpen && time.Since(b.openedAt) >= b.timeout {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+// setState must be called with b.mu held.
+func (b *breaker) setState(state int) {
+	if b.current == state {
+		return
+	}
+	b.current = state
+	b.onChange(state)
+}
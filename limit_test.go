@@ -0,0 +1,161 @@
+package jiralert
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLimitClient returns a client sending its requests to a server answering them with the status codes in turn,
+// 200 once they are used up, through a limitTransport configured by a.
+func testLimitClient(t *testing.T, a *APIConfig, statuses ...int) (*http.Client, *limitTransport, *int32) {
+	var (
+		mu       sync.Mutex
+		requests int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		atomic.AddInt32(&requests, 1)
+		if len(statuses) == 0 {
+			return
+		}
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	a.Name, a.URL = DefaultAPI, server.URL
+	transport := newLimitTransport(a, http.DefaultTransport)
+	return &http.Client{Transport: transport}, transport, &requests
+}
+
+// get sends a GET request with client and returns the response status code.
+func get(client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestBreaker(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		trial int
+		want  int
+	}{
+		{name: "trial succeeds", trial: http.StatusOK, want: BreakerClosed},
+		{name: "trial fails", trial: http.StatusBadGateway, want: BreakerOpen},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := &APIConfig{CircuitBreaker: CircuitBreakerConfig{Failures: 3, Timeout: 50 * time.Millisecond}}
+			statuses := []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusInternalServerError, tc.trial}
+			client, transport, requests := testLimitClient(t, a, statuses...)
+			var (
+				mu     sync.Mutex
+				states []int
+			)
+			transport.breaker.onChange = func(state int) {
+				mu.Lock()
+				states = append(states, state)
+				mu.Unlock()
+				transport.breakerChanged(state)
+			}
+			lastState := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return states[len(states)-1]
+			}
+
+			for i := 0; i < 3; i++ {
+				if status, err := get(client, a.URL); err != nil || status < 500 {
+					t.Fatalf("request %d: got %d (%v), want a server error", i, status, err)
+				}
+			}
+			if _, err := get(client, a.URL); !errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("got %v, want the request rejected by the open breaker", err)
+			}
+			if n := atomic.LoadInt32(requests); n != 3 {
+				t.Errorf("got %d requests sent, want 3", n)
+			}
+			if !transport.CircuitOpen() || lastState() != BreakerOpen {
+				t.Fatalf("got breaker state %d, want it open", lastState())
+			}
+
+			// The half-open state is reported once the timeout expires, before any request.
+			waitFor(t, "the half-open breaker", func() bool { return lastState() == BreakerHalfOpen })
+			if transport.CircuitOpen() {
+				t.Error("got the breaker open after its timeout")
+			}
+			if status, err := get(client, a.URL); err != nil || status != tc.trial {
+				t.Fatalf("trial request: got %d (%v), want %d", status, err, tc.trial)
+			}
+			if got := lastState(); got != tc.want {
+				t.Errorf("got breaker state %d after the trial request, want %d", got, tc.want)
+			}
+			if _, err := get(client, a.URL); (tc.want == BreakerOpen) != errors.Is(err, ErrCircuitOpen) {
+				t.Errorf("request after the trial: got error %v with breaker state %d", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	a := &APIConfig{}
+	client, _, requests := testLimitClient(t, a, http.StatusTooManyRequests)
+	start := time.Now()
+	if status, err := get(client, a.URL); err != nil || status != http.StatusOK {
+		t.Fatalf("got %d (%v), want the request retried", status, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the 1s of Retry-After", elapsed)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("got %d requests sent, want 2", n)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	a := &APIConfig{RateLimit: RateLimitConfig{Rate: 20, Burst: 2}}
+	client, _, requests := testLimitClient(t, a)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if status, err := get(client, a.URL); err != nil || status != http.StatusOK {
+			t.Fatalf("request %d: got %d (%v)", i, status, err)
+		}
+	}
+	// The burst goes through at once, the next requests wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("sent 4 requests in %s, want at least 100ms at 20 requests per second with a burst of 2", elapsed)
+	}
+	if n := atomic.LoadInt32(requests); n != 4 {
+		t.Errorf("got %d requests sent, want 4", n)
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{"", time.Second},
+		{"0", time.Second},
+		{"-1", time.Second},
+	} {
+		if got := retryAfter(tc.value, now); got != tc.want {
+			t.Errorf("Retry-After %q: got %s, want %s", tc.value, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,838 @@
+package jiralert
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The descriptions and comments of issues usually come from alert annotations written in Markdown (or plain text,
+// which reads the same), while JIRA Server renders its wiki markup and the JIRA Cloud REST API v3 takes Atlassian
+// Document Format documents. ToJiraWiki and ToADF convert the subset of Markdown found in annotations: headings,
+// paragraphs, fenced code blocks, nested bullet and ordered lists, tables, block quotes, horizontal rules and the
+// inline code, strong, emphasis, strikethrough and link spans. Line breaks within paragraphs are kept, as in plain
+// text.
+
+// ADFNode is a node of an Atlassian Document Format document.
+type ADFNode struct {
+	Type    string                 `json:"type"`
+	Version int                    `json:"version,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Content []*ADFNode             `json:"content,omitempty"`
+	Text    string                 `json:"text,omitempty"`
+	Marks   []*ADFMark             `json:"marks,omitempty"`
+}
+
+// ADFMark is the formatting of an Atlassian Document Format text node.
+type ADFMark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// ToJiraWiki converts Markdown to JIRA wiki markup.
+func ToJiraWiki(markdown string) string {
+	var buf strings.Builder
+	writeWikiBlocks(&buf, parseMarkdown(markdown))
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+// ToADF converts Markdown to an Atlassian Document Format document.
+func ToADF(markdown string) *ADFNode {
+	doc := &ADFNode{Type: "doc", Version: 1, Content: adfBlocks(parseMarkdown(markdown))}
+	if len(doc.Content) == 0 {
+		doc.Content = []*ADFNode{{Type: "paragraph"}}
+	}
+	return doc
+}
+
+// PlainToADF converts plain text to an Atlassian Document Format document, made of a paragraph per block of lines.
+func PlainToADF(text string) *ADFNode {
+	doc := &ADFNode{Type: "doc", Version: 1}
+	for _, block := range strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n\n") {
+		paragraph := &ADFNode{Type: "paragraph"}
+		for i, line := range strings.Split(strings.Trim(block, "\n"), "\n") {
+			if i > 0 {
+				paragraph.Content = append(paragraph.Content, &ADFNode{Type: "hardBreak"})
+			}
+			if line != "" {
+				paragraph.Content = append(paragraph.Content, &ADFNode{Type: "text", Text: line})
+			}
+		}
+		if len(paragraph.Content) > 0 {
+			doc.Content = append(doc.Content, paragraph)
+		}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*ADFNode{{Type: "paragraph"}}
+	}
+	return doc
+}
+
+// Markdown block kinds.
+const (
+	mdParagraph = iota
+	mdHeading
+	mdCode
+	mdList
+	mdQuote
+	mdRule
+	mdTable
+)
+
+// mdBlock is a Markdown block.
+type mdBlock struct {
+	kind int
+	// text is the content of paragraphs, headings and code blocks
+	text  string
+	level int    // heading level
+	lang  string // code block language
+	// ordered lists start at start
+	ordered bool
+	start   int
+	items   [][]*mdBlock
+	rows    [][]string // table rows, the first one is the header
+	quote   []*mdBlock
+}
+
+var (
+	mdHeadingRe = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdFenceRe   = regexp.MustCompile("^( {0,3})(```+|~~~+)[ \t]*([^`\\s]*)")
+	mdRuleRe    = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuoteRe   = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+	mdListRe    = regexp.MustCompile(`^([ \t]*)([-*+]|\d{1,9}[.)])([ \t]+|$)(.*)$`)
+	mdTableSep  = regexp.MustCompile(`^[ \t]*\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+)
+
+func parseMarkdown(markdown string) []*mdBlock {
+	markdown = strings.Replace(markdown, "\r\n", "\n", -1)
+	return parseBlocks(strings.Split(markdown, "\n"))
+}
+
+func parseBlocks(lines []string) []*mdBlock {
+	var blocks []*mdBlock
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case isBlank(line):
+			i++
+		case mdFenceRe.MatchString(line):
+			var b *mdBlock
+			b, i = parseFence(lines, i)
+			blocks = append(blocks, b)
+		case mdHeadingRe.MatchString(line):
+			m := mdHeadingRe.FindStringSubmatch(line)
+			blocks = append(blocks, &mdBlock{kind: mdHeading, level: len(m[1]), text: m[2]})
+			i++
+		case mdRuleRe.MatchString(line):
+			blocks = append(blocks, &mdBlock{kind: mdRule})
+			i++
+		case mdQuoteRe.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && mdQuoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, mdQuoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			blocks = append(blocks, &mdBlock{kind: mdQuote, quote: parseBlocks(quoted)})
+		case isTableStart(lines, i):
+			b := &mdBlock{kind: mdTable, rows: [][]string{splitRow(line)}}
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|") && !isBlank(lines[i]); i++ {
+				b.rows = append(b.rows, splitRow(lines[i]))
+			}
+			blocks = append(blocks, b)
+		case mdListRe.MatchString(line):
+			var b *mdBlock
+			b, i = parseList(lines, i)
+			blocks = append(blocks, b)
+		default:
+			paragraph := []string{strings.TrimSpace(line)}
+			for i++; i < len(lines) && !isBlank(lines[i]) && !isBlockStart(lines, i); i++ {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+			}
+			blocks = append(blocks, &mdBlock{kind: mdParagraph, text: strings.Join(paragraph, "\n")})
+		}
+	}
+	return blocks
+}
+
+// parseFence parses the fenced code block starting at lines[i], it returns the block and the index of the next line.
+func parseFence(lines []string, i int) (*mdBlock, int) {
+	m := mdFenceRe.FindStringSubmatch(lines[i])
+	indent, fence := len(m[1]), m[2]
+	b := &mdBlock{kind: mdCode, lang: m[3]}
+	var code []string
+	for i++; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		line := lines[i]
+		for n := 0; n < indent && strings.HasPrefix(line, " "); n++ {
+			line = line[1:]
+		}
+		code = append(code, line)
+	}
+	b.text = strings.Join(code, "\n")
+	return b, i
+}
+
+// parseList parses the list starting at lines[i], it returns the block and the index of the next line. Lines
+// indented more than the list markers belong to the current item, and may hold nested lists.
+func parseList(lines []string, i int) (*mdBlock, int) {
+	m := mdListRe.FindStringSubmatch(lines[i])
+	base := indentation(m[1])
+	b := &mdBlock{kind: mdList, ordered: isOrdered(m[2])}
+	if b.ordered {
+		b.start, _ = strconv.Atoi(strings.TrimRight(m[2], ".)"))
+	}
+
+	var item []string
+	contentIndent := 0
+	flush := func() {
+		if item != nil {
+			b.items = append(b.items, parseBlocks(item))
+		}
+	}
+	for i < len(lines) {
+		line := lines[i]
+		if isBlank(line) {
+			next := i + 1
+			for next < len(lines) && isBlank(lines[next]) {
+				next++
+			}
+			if next == len(lines) {
+				break
+			}
+			if indentation(lines[next]) > base {
+				item = append(item, "")
+				i++
+				continue
+			}
+			if m := mdListRe.FindStringSubmatch(lines[next]); m != nil && isOrdered(m[2]) == b.ordered {
+				i = next
+				continue
+			}
+			break
+		}
+
+		indent := indentation(line)
+		if m := mdListRe.FindStringSubmatch(line); m != nil && indent <= base && !mdRuleRe.MatchString(line) {
+			if isOrdered(m[2]) != b.ordered {
+				break
+			}
+			flush()
+			item = []string{m[4]}
+			contentIndent = indent + len(m[2]) + len(m[3])
+			if len(m[3]) > 4 || m[4] == "" {
+				contentIndent = indent + len(m[2]) + 1
+			}
+			i++
+			continue
+		}
+		if indent > base {
+			if indent > contentIndent {
+				indent = contentIndent
+			}
+			item = append(item, dedent(line, indent))
+			i++
+			continue
+		}
+		// Lazy continuation of the item's last paragraph.
+		if len(item) > 0 && !isBlank(item[len(item)-1]) && !isBlockStart(lines, i) {
+			item = append(item, strings.TrimSpace(line))
+			i++
+			continue
+		}
+		break
+	}
+	flush()
+	return b, i
+}
+
+// isBlockStart returns whether lines[i] starts a block other than a paragraph, ending the current paragraph.
+func isBlockStart(lines []string, i int) bool {
+	line := lines[i]
+	return mdFenceRe.MatchString(line) || mdHeadingRe.MatchString(line) || mdRuleRe.MatchString(line) ||
+		mdQuoteRe.MatchString(line) || mdListRe.MatchString(line) || isTableStart(lines, i)
+}
+
+func isTableStart(lines []string, i int) bool {
+	return strings.Contains(lines[i], "|") && i+1 < len(lines) && strings.Contains(lines[i+1], "|") &&
+		strings.Contains(lines[i+1], "-") && mdTableSep.MatchString(lines[i+1])
+}
+
+// splitRow returns the cells of a table row, split on the unescaped pipes.
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, `\|`) {
+		line = line[:len(line)-1]
+	}
+	var (
+		cells []string
+		cell  strings.Builder
+	)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isOrdered(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+// indentation returns the width of the leading whitespace of line, tabs counting as 4 spaces.
+func indentation(line string) int {
+	width := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// dedent removes up to width columns of leading whitespace from line.
+func dedent(line string, width int) string {
+	for width > 0 && line != "" {
+		switch line[0] {
+		case ' ':
+			width--
+		case '\t':
+			width -= 4
+		default:
+			return line
+		}
+		line = line[1:]
+	}
+	return line
+}
+
+// mdSpan is a run of inline text sharing the same formatting.
+type mdSpan struct {
+	text   string
+	code   bool
+	strong bool
+	em     bool
+	strike bool
+	href   string
+}
+
+// parseInline splits the inline Markdown text into spans.
+func parseInline(text string) []mdSpan {
+	var spans []mdSpan
+	parseSpans(text, mdSpan{}, &spans)
+	return spans
+}
+
+// parseSpans appends the spans of text to spans, with the formatting of outer.
+func parseSpans(text string, outer mdSpan, spans *[]mdSpan) {
+	var plain strings.Builder
+	add := func(span mdSpan) {
+		if span.text == "" {
+			return
+		}
+		if n := len(*spans); n > 0 {
+			if last := &(*spans)[n-1]; last.code == span.code && last.strong == span.strong && last.em == span.em &&
+				last.strike == span.strike && last.href == span.href {
+				last.text += span.text
+				return
+			}
+		}
+		*spans = append(*spans, span)
+	}
+	flush := func() {
+		span := outer
+		span.text = plain.String()
+		add(span)
+		plain.Reset()
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_~[]()<>#+-.!|{}", text[i+1]) >= 0:
+			plain.WriteByte(text[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			n := runLength(text, i, '`')
+			fence := text[i : i+n]
+			if end := strings.Index(text[i+n:], fence); end >= 0 {
+				flush()
+				code := text[i+n : i+n+end]
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				add(mdSpan{text: code, code: true, href: outer.href})
+				i += 2*n + end
+				continue
+			}
+			plain.WriteString(fence)
+			i += n
+			continue
+
+		case c == '[':
+			if label, href, end, ok := parseLink(text, i); ok {
+				flush()
+				inner := outer
+				inner.href = href
+				parseSpans(label, inner, spans)
+				i = end
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(text[i:], '>'); end > 0 && isURL(text[i+1:i+end]) {
+				flush()
+				url := text[i+1 : i+end]
+				add(mdSpan{text: url, href: url})
+				i += end + 1
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if inner, n, end, ok := parseEmphasis(text, i); ok {
+				flush()
+				span := outer
+				switch {
+				case c == '~':
+					span.strike = true
+				case n == 2:
+					span.strong = true
+				default:
+					span.em = true
+				}
+				parseSpans(inner, span, spans)
+				i = end
+				continue
+			}
+
+		case c == 'h' && outer.href == "" && (i == 0 || !isWordChar(text[i-1])) && isURL(text[i:]):
+			end := i
+			for end < len(text) && text[end] != ' ' && text[end] != '\t' && text[end] != '\n' && text[end] != '<' {
+				end++
+			}
+			for end > i && strings.IndexByte(".,;:!?)'\"", text[end-1]) >= 0 {
+				end--
+			}
+			flush()
+			add(mdSpan{text: text[i:end], href: text[i:end]})
+			i = end
+			continue
+		}
+		plain.WriteByte(c)
+		i++
+	}
+	flush()
+}
+
+// parseLink parses the [label](href) link starting at text[i], it returns the index following it.
+func parseLink(text string, i int) (label, href string, end int, ok bool) {
+	bracket := matching(text, i, '[', ']')
+	if bracket < 0 || bracket+1 >= len(text) || text[bracket+1] != '(' {
+		return "", "", 0, false
+	}
+	paren := matching(text, bracket+1, '(', ')')
+	if paren < 0 {
+		return "", "", 0, false
+	}
+	target := strings.Fields(text[bracket+2 : paren])
+	if len(target) == 0 {
+		return "", "", 0, false
+	}
+	return text[i+1 : bracket], strings.Trim(target[0], "<>"), paren + 1, true
+}
+
+// matching returns the index of the bracket closing the one at text[i], or -1.
+func matching(text string, i int, open, close byte) int {
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
+
+// parseEmphasis parses the emphasis, strong or strikethrough span starting at text[i]. It returns the inner text, the
+// delimiter length and the index following the span.
+func parseEmphasis(text string, i int) (inner string, n, end int, ok bool) {
+	c := text[i]
+	n = 1
+	if i+1 < len(text) && text[i+1] == c {
+		n = 2
+	}
+	if c == '~' && n != 2 {
+		return "", 0, 0, false
+	}
+	// The opening delimiter must be followed by text, and underscores must not be within a word, e.g. snake_case.
+	if i+n >= len(text) || text[i+n] == ' ' || text[i+n] == '\n' || (c == '_' && i > 0 && isWordChar(text[i-1])) {
+		return "", 0, 0, false
+	}
+	delim := text[i : i+n]
+	for j := i + n + 1; j+n <= len(text); j++ {
+		if text[j-1] == '\\' {
+			continue
+		}
+		if text[j:j+n] != delim || text[j-1] == ' ' || text[j-1] == '\n' || text[j-1] == c ||
+			(j+n < len(text) && text[j+n] == c) || (c == '_' && j+n < len(text) && isWordChar(text[j+n])) {
+			continue
+		}
+		return text[i+n : j], n, j + n, true
+	}
+	return "", 0, 0, false
+}
+
+func runLength(text string, i int, c byte) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func isURL(text string) bool {
+	for _, scheme := range []string{"http://", "https://"} {
+		if strings.HasPrefix(text, scheme) && len(text) > len(scheme) {
+			return !strings.ContainsAny(text[:strings.IndexAny(text+" ", " \t\n")], "<>")
+		}
+	}
+	return false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// wikiEscaper escapes the characters of plain text that JIRA wiki markup would interpret.
+var wikiEscaper = strings.NewReplacer(
+	"{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`, "|", `\|`, "*", `\*`, "_", `\_`, "~", `\~`, "^", `\^`, "!", `\!`,
+)
+
+func writeWikiBlocks(buf *strings.Builder, blocks []*mdBlock) {
+	for _, b := range blocks {
+		switch b.kind {
+		case mdParagraph:
+			buf.WriteString(wikiInline(b.text))
+		case mdHeading:
+			buf.WriteString("h" + strconv.Itoa(b.level) + ". " + wikiInline(b.text))
+		case mdCode:
+			writeWikiCode(buf, b)
+		case mdList:
+			writeWikiList(buf, b, "")
+			continue
+		case mdQuote:
+			var quote strings.Builder
+			writeWikiBlocks(&quote, b.quote)
+			buf.WriteString("{quote}\n" + strings.TrimRight(quote.String(), "\n") + "\n{quote}")
+		case mdRule:
+			buf.WriteString("----")
+		case mdTable:
+			for i, row := range b.rows {
+				sep := "|"
+				if i == 0 {
+					sep = "||"
+				}
+				for _, cell := range row {
+					if cell = wikiInline(cell); cell == "" {
+						cell = " "
+					}
+					buf.WriteString(sep + cell)
+				}
+				buf.WriteString(sep + "\n")
+			}
+			buf.WriteString("\n")
+			continue
+		}
+		buf.WriteString("\n\n")
+	}
+}
+
+func writeWikiCode(buf *strings.Builder, b *mdBlock) {
+	if b.lang == "" {
+		buf.WriteString("{noformat}\n" + b.text + "\n{noformat}")
+	} else {
+		buf.WriteString("{code:" + b.lang + "}\n" + b.text + "\n{code}")
+	}
+}
+
+// writeWikiList writes the list, prefix holding the markers of the enclosing lists. The paragraphs of an item are
+// separated by forced line breaks, wiki markup list items being a single line.
+func writeWikiList(buf *strings.Builder, b *mdBlock, prefix string) {
+	if b.ordered {
+		prefix += "#"
+	} else {
+		prefix += "*"
+	}
+	for _, item := range b.items {
+		var text []string
+		flush := func() {
+			buf.WriteString(prefix + " " + strings.Join(text, ` \\ `) + "\n")
+			text = nil
+		}
+		for i, child := range item {
+			switch child.kind {
+			case mdList:
+				if i == 0 || text != nil {
+					flush()
+				}
+				writeWikiList(buf, child, prefix)
+			case mdCode:
+				if i == 0 || text != nil {
+					flush()
+				}
+				writeWikiCode(buf, child)
+				buf.WriteString("\n")
+			default:
+				text = append(text, strings.Replace(wikiInline(child.text), "\n", ` \\ `, -1))
+			}
+		}
+		if len(item) == 0 || text != nil {
+			flush()
+		}
+	}
+	if prefix == "*" || prefix == "#" {
+		buf.WriteString("\n")
+	}
+}
+
+// wikiInline converts inline Markdown to wiki markup.
+func wikiInline(text string) string {
+	spans := parseInline(text)
+	var buf strings.Builder
+	for i := 0; i < len(spans); {
+		// The spans of a link are written within a single [label|href], the other spans up to the next link together.
+		href := spans[i].href
+		j := i
+		for j < len(spans) && spans[j].href == href {
+			j++
+		}
+		if href == "" {
+			buf.WriteString(wikiSpans(spans[i:j]))
+			i = j
+			continue
+		}
+		var plain strings.Builder
+		for _, span := range spans[i:j] {
+			plain.WriteString(span.text)
+		}
+		if plain.String() == href {
+			buf.WriteString("[" + href + "]")
+		} else {
+			buf.WriteString("[" + wikiSpans(spans[i:j]) + "|" + href + "]")
+		}
+		i = j
+	}
+	return buf.String()
+}
+
+// wikiSpans writes the spans, the formatting shared by consecutive spans within a single pair of markers: JIRA does
+// not render *very **_much_** so*, written for **very _much_ so** span by span.
+func wikiSpans(spans []mdSpan) string {
+	var (
+		buf  strings.Builder
+		open []string // markers of the formatting in effect, innermost last
+	)
+	for _, span := range spans {
+		markers := wikiMarkers(span)
+		// Close the formatting the span lacks, along with the formatting nested in it.
+		keep := 0
+		for keep < len(open) && containsString(markers, open[keep]) {
+			keep++
+		}
+		for i := len(open) - 1; i >= keep; i-- {
+			buf.WriteString(open[i])
+		}
+		open = open[:keep]
+		for _, marker := range markers {
+			if !containsString(open, marker) {
+				buf.WriteString(marker)
+				open = append(open, marker)
+			}
+		}
+		text := wikiEscaper.Replace(span.text)
+		if span.code {
+			text = "{{" + text + "}}"
+		}
+		buf.WriteString(text)
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		buf.WriteString(open[i])
+	}
+	return buf.String()
+}
+
+// wikiMarkers returns the wiki markers of the formatting of the span, outermost first.
+func wikiMarkers(span mdSpan) []string {
+	var markers []string
+	if span.strong {
+		markers = append(markers, "*")
+	}
+	if span.em {
+		markers = append(markers, "_")
+	}
+	if span.strike {
+		markers = append(markers, "-")
+	}
+	return markers
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func adfBlocks(blocks []*mdBlock) []*ADFNode {
+	var nodes []*ADFNode
+	for _, b := range blocks {
+		switch b.kind {
+		case mdParagraph:
+			nodes = append(nodes, &ADFNode{Type: "paragraph", Content: adfInline(b.text)})
+		case mdHeading:
+			nodes = append(nodes, &ADFNode{Type: "heading", Attrs: map[string]interface{}{"level": b.level}, Content: adfInline(b.text)})
+		case mdCode:
+			node := &ADFNode{Type: "codeBlock"}
+			if b.lang != "" {
+				node.Attrs = map[string]interface{}{"language": b.lang}
+			}
+			if b.text != "" {
+				node.Content = []*ADFNode{{Type: "text", Text: b.text}}
+			}
+			nodes = append(nodes, node)
+		case mdList:
+			node := &ADFNode{Type: "bulletList"}
+			if b.ordered {
+				node.Type = "orderedList"
+				if b.start != 1 {
+					node.Attrs = map[string]interface{}{"order": b.start}
+				}
+			}
+			for _, item := range b.items {
+				content := adfNested(item)
+				if len(content) == 0 || content[0].Type != "paragraph" {
+					content = append([]*ADFNode{{Type: "paragraph"}}, content...)
+				}
+				node.Content = append(node.Content, &ADFNode{Type: "listItem", Content: content})
+			}
+			nodes = append(nodes, node)
+		case mdQuote:
+			nodes = append(nodes, &ADFNode{Type: "blockquote", Content: adfNested(b.quote)})
+		case mdRule:
+			nodes = append(nodes, &ADFNode{Type: "rule"})
+		case mdTable:
+			table := &ADFNode{Type: "table"}
+			for i, row := range b.rows {
+				cellType := "tableCell"
+				if i == 0 {
+					cellType = "tableHeader"
+				}
+				node := &ADFNode{Type: "tableRow"}
+				for _, cell := range row {
+					node.Content = append(node.Content, &ADFNode{Type: cellType, Content: []*ADFNode{
+						{Type: "paragraph", Content: adfInline(cell)},
+					}})
+				}
+				table.Content = append(table.Content, node)
+			}
+			nodes = append(nodes, table)
+		}
+	}
+	return nodes
+}
+
+// adfNested converts the blocks of a list item or block quote, which may only hold paragraphs, lists and code
+// blocks: headings become paragraphs, the content of nested quotes is inlined and tables are flattened.
+func adfNested(blocks []*mdBlock) []*ADFNode {
+	var nodes []*ADFNode
+	for _, node := range adfBlocks(blocks) {
+		switch node.Type {
+		case "heading":
+			node.Type, node.Attrs = "paragraph", nil
+		case "blockquote":
+			nodes = append(nodes, node.Content...)
+			continue
+		case "rule":
+			continue
+		case "table":
+			for _, row := range node.Content {
+				paragraph := &ADFNode{Type: "paragraph"}
+				for i, cell := range row.Content {
+					if i > 0 {
+						paragraph.Content = append(paragraph.Content, &ADFNode{Type: "text", Text: " | "})
+					}
+					paragraph.Content = append(paragraph.Content, cell.Content[0].Content...)
+				}
+				nodes = append(nodes, paragraph)
+			}
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// adfInline converts inline Markdown to ADF text nodes, line breaks becoming hard breaks.
+func adfInline(text string) []*ADFNode {
+	var nodes []*ADFNode
+	for _, span := range parseInline(text) {
+		var marks []*ADFMark
+		if span.code {
+			// Code may only be combined with links.
+			marks = append(marks, &ADFMark{Type: "code"})
+		} else {
+			if span.strong {
+				marks = append(marks, &ADFMark{Type: "strong"})
+			}
+			if span.em {
+				marks = append(marks, &ADFMark{Type: "em"})
+			}
+			if span.strike {
+				marks = append(marks, &ADFMark{Type: "strike"})
+			}
+		}
+		if span.href != "" {
+			marks = append(marks, &ADFMark{Type: "link", Attrs: map[string]interface{}{"href": span.href}})
+		}
+		for i, line := range strings.Split(span.text, "\n") {
+			if i > 0 {
+				nodes = append(nodes, &ADFNode{Type: "hardBreak"})
+			}
+			if line != "" {
+				nodes = append(nodes, &ADFNode{Type: "text", Text: line, Marks: marks})
+			}
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,182 @@
+package jiralert
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestToJiraWiki(t *testing.T) {
+	for _, tc := range []struct {
+		name, markdown, wiki string
+	}{
+		{"empty", "", ""},
+		{"paragraph", "Disk is *almost* full", "Disk is _almost_ full"},
+		{"line breaks", "first line\nsecond line", "first line\nsecond line"},
+		{"paragraphs", "first\n\nsecond", "first\n\nsecond"},
+		{"heading", "# Title", "h1. Title"},
+		{"heading with closing hashes", "### Deep *dive* ###", "h3. Deep _dive_"},
+		{"emphasis", "*one* and _two_", "_one_ and _two_"},
+		{"strong", "**one** and __two__", "*one* and *two*"},
+		{"strong emphasis", "**very _much_ so**", "*very _much_ so*"},
+		{"strong emphasis at the end", "**very _much_**", "*very _much_*"},
+		{"strikethrough", "~~gone~~", "-gone-"},
+		{"strikethrough within emphasis", "*a ~~b~~* c", "_a -b-_ c"},
+		{"inline code", "run `ls -l *` now", `run {{ls -l \*}} now`},
+		{"double backtick code", "``a ` b``", "{{a ` b}}"},
+		{"unterminated emphasis", "a *b", `a \*b`},
+		{"unterminated strong", "**open", `\*\*open`},
+		{"unterminated strikethrough", "~~open", `\~\~open`},
+		{"unterminated code", "`open", "`open"},
+		{"spaced asterisk", "2 * 3 = 6", `2 \* 3 = 6`},
+		{"snake case", "snake_case_name", `snake\_case\_name`},
+		{"backslash escape", `\*not em\*`, `\*not em\*`},
+		{"wiki characters", "a {b} [c] |d| ^e^ !f!", `a \{b\} \[c\] \|d\| \^e\^ \!f\!`},
+		{"link", "see [the docs](https://example.com/docs)", "see [the docs|https://example.com/docs]"},
+		{"link with title", `[docs](https://example.com "Docs")`, "[docs|https://example.com]"},
+		{"link with formatted label", "[**docs**](https://example.com)", "[*docs*|https://example.com]"},
+		{"link with brackets in label", "[a [b] c](https://example.com)", `[a \[b\] c|https://example.com]`},
+		{"unterminated link", "[docs](https://example.com", `\[docs\]([https://example.com]`},
+		{"autolink", "<https://example.com/a>", "[https://example.com/a]"},
+		{"bare URL", "see https://example.com/a.", "see [https://example.com/a]."},
+		{"bare URL in parentheses", "(https://example.com/a)", "([https://example.com/a])"},
+		{"fenced code", "```go\nfmt.Println(\"*\")\n```", "{code:go}\nfmt.Println(\"*\")\n{code}"},
+		{"fenced code without language", "```\n$ ls *\n```", "{noformat}\n$ ls *\n{noformat}"},
+		{"tilde fence", "~~~sh\necho\n~~~", "{code:sh}\necho\n{code}"},
+		{"indented fence", "  ```\n  a\n    b\n  ```", "{noformat}\na\n  b\n{noformat}"},
+		{"unterminated fence", "```\ncode\n\nmore", "{noformat}\ncode\n\nmore\n{noformat}"},
+		{"fence within paragraph", "text\n```\ncode\n```", "text\n\n{noformat}\ncode\n{noformat}"},
+		{"bullet list", "- a\n- b\n* c", "* a\n* b\n* c"},
+		{"ordered list", "1. a\n2. b", "# a\n# b"},
+		{"nested lists", "- a\n  - b\n    - c\n- d", "* a\n** b\n*** c\n* d"},
+		{"mixed nested lists", "1. one\n   - sub\n   - sub\n2. two", "# one\n#* sub\n#* sub\n# two"},
+		{"tab indented nested list", "- a\n\t- b", "* a\n** b"},
+		{"list item paragraphs", "- a\n\n  more\n- b", `* a \\ more` + "\n* b"},
+		{"list item lazy continuation", "- a\ncontinued\n- b", `* a \\ continued` + "\n* b"},
+		{"list item code", "- step\n\n  ```\n  cmd\n  ```", "* step\n{noformat}\ncmd\n{noformat}"},
+		{"list then paragraph", "- a\n- b\n\ntext", "* a\n* b\n\ntext"},
+		{"list item formatting", "- **bold** [link](https://example.com)", "* *bold* [link|https://example.com]"},
+		{"table", "| A | B |\n|---|:-:|\n| 1 | `x` |", "||A||B||\n|1|{{x}}|"},
+		{"table without outer pipes", "A | B\n--- | ---\n1 | 2", "||A||B||\n|1|2|"},
+		{"table with empty and escaped cells", "| A | B |\n|---|---|\n|  | a \\| b |", "||A||B||\n| |a \\| b|"},
+		{"pipe without table", "a | b\nc | d", `a \| b` + "\n" + `c \| d`},
+		{"block quote", "> quoted\n> *text*", "{quote}\nquoted\n_text_\n{quote}"},
+		{"block quote with list", "> - a\n> - b", "{quote}\n* a\n* b\n{quote}"},
+		{"rule", "a\n\n---\n\nb", "a\n\n----\n\nb"},
+		{"rule of asterisks", "* * *", "----"},
+		{"CRLF", "# T\r\n\r\ntext", "h1. T\n\ntext"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ToJiraWiki(tc.markdown); got != tc.wiki {
+				t.Errorf("ToJiraWiki(%q) = %q, want %q", tc.markdown, got, tc.wiki)
+			}
+		})
+	}
+}
+
+func TestToADF(t *testing.T) {
+	for _, tc := range []struct {
+		name, markdown string
+		// content is the JSON encoding of the content of the document
+		content string
+	}{
+		{"empty", "", `[{"type":"paragraph"}]`},
+		{"paragraph", "Disk is full",
+			`[{"type":"paragraph","content":[{"type":"text","text":"Disk is full"}]}]`},
+		{"line breaks", "a\nb",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a"},{"type":"hardBreak"},{"type":"text","text":"b"}]}]`},
+		{"paragraphs", "a\n\nb",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a"}]},{"type":"paragraph","content":[{"type":"text","text":"b"}]}]`},
+		{"heading", "## Title",
+			`[{"type":"heading","attrs":{"level":2},"content":[{"type":"text","text":"Title"}]}]`},
+		{"marks", "*em* **strong** ~~strike~~ `code`",
+			`[{"type":"paragraph","content":[` +
+				`{"type":"text","text":"em","marks":[{"type":"em"}]},{"type":"text","text":" "},` +
+				`{"type":"text","text":"strong","marks":[{"type":"strong"}]},{"type":"text","text":" "},` +
+				`{"type":"text","text":"strike","marks":[{"type":"strike"}]},{"type":"text","text":" "},` +
+				`{"type":"text","text":"code","marks":[{"type":"code"}]}]}]`},
+		{"nested marks", "**a _b_**",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a ","marks":[{"type":"strong"}]},` +
+				`{"type":"text","text":"b","marks":[{"type":"strong"},{"type":"em"}]}]}]`},
+		{"unterminated emphasis", "a *b and __c",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a *b and __c"}]}]`},
+		{"link", "[docs](https://example.com)",
+			`[{"type":"paragraph","content":[{"type":"text","text":"docs","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]}]}]`},
+		{"code link", "[`docs`](https://example.com)",
+			`[{"type":"paragraph","content":[{"type":"text","text":"docs","marks":[{"type":"code"},{"type":"link","attrs":{"href":"https://example.com"}}]}]}]`},
+		{"bare URL", "see https://example.com.",
+			`[{"type":"paragraph","content":[{"type":"text","text":"see "},` +
+				`{"type":"text","text":"https://example.com","marks":[{"type":"link","attrs":{"href":"https://example.com"}}]},` +
+				`{"type":"text","text":"."}]}]`},
+		{"fenced code", "```go\na := 1\nb := 2\n```",
+			`[{"type":"codeBlock","attrs":{"language":"go"},"content":[{"type":"text","text":"a := 1\nb := 2"}]}]`},
+		{"empty fenced code", "```\n```", `[{"type":"codeBlock"}]`},
+		{"unterminated fence", "```\n**code**",
+			`[{"type":"codeBlock","content":[{"type":"text","text":"**code**"}]}]`},
+		{"bullet list", "- a\n- b",
+			`[{"type":"bulletList","content":[` +
+				`{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]},` +
+				`{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"b"}]}]}]}]`},
+		{"ordered list", "3. a",
+			`[{"type":"orderedList","attrs":{"order":3},"content":[` +
+				`{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]}]`},
+		{"nested lists", "1. a\n   - b\n     ```\n     c\n     ```",
+			`[{"type":"orderedList","content":[{"type":"listItem","content":[` +
+				`{"type":"paragraph","content":[{"type":"text","text":"a"}]},` +
+				`{"type":"bulletList","content":[{"type":"listItem","content":[` +
+				`{"type":"paragraph","content":[{"type":"text","text":"b"}]},` +
+				`{"type":"codeBlock","content":[{"type":"text","text":"c"}]}]}]}]}]}]`},
+		{"list item starting with a list", "- - a",
+			`[{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph"},` +
+				`{"type":"bulletList","content":[{"type":"listItem","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]}]}]}]}]}]`},
+		{"table", "| A | B |\n|---|---|\n| 1 | **2** |",
+			`[{"type":"table","content":[` +
+				`{"type":"tableRow","content":[` +
+				`{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"A"}]}]},` +
+				`{"type":"tableHeader","content":[{"type":"paragraph","content":[{"type":"text","text":"B"}]}]}]},` +
+				`{"type":"tableRow","content":[` +
+				`{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"1"}]}]},` +
+				`{"type":"tableCell","content":[{"type":"paragraph","content":[{"type":"text","text":"2","marks":[{"type":"strong"}]}]}]}]}]}]`},
+		{"block quote", "> # a\n> b",
+			`[{"type":"blockquote","content":[{"type":"paragraph","content":[{"type":"text","text":"a"}]},` +
+				`{"type":"paragraph","content":[{"type":"text","text":"b"}]}]}]`},
+		{"rule", "a\n\n***",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a"}]},{"type":"rule"}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := ToADF(tc.markdown)
+			if doc.Type != "doc" || doc.Version != 1 {
+				t.Errorf("ToADF(%q) is a %q node version %d, want a doc version 1", tc.markdown, doc.Type, doc.Version)
+			}
+			content, err := json.Marshal(doc.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.content {
+				t.Errorf("ToADF(%q) content =\n%s\nwant\n%s", tc.markdown, content, tc.content)
+			}
+		})
+	}
+}
+
+func TestPlainToADF(t *testing.T) {
+	for _, tc := range []struct {
+		name, text, content string
+	}{
+		{"empty", "", `[{"type":"paragraph"}]`},
+		{"markdown kept", "**a** [b](c)",
+			`[{"type":"paragraph","content":[{"type":"text","text":"**a** [b](c)"}]}]`},
+		{"lines and paragraphs", "a\nb\n\n\nc",
+			`[{"type":"paragraph","content":[{"type":"text","text":"a"},{"type":"hardBreak"},{"type":"text","text":"b"}]},` +
+				`{"type":"paragraph","content":[{"type":"text","text":"c"}]}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := json.Marshal(PlainToADF(tc.text).Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.content {
+				t.Errorf("PlainToADF(%q) content =\n%s\nwant\n%s", tc.text, content, tc.content)
+			}
+		})
+	}
+}
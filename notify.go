@@ -3,197 +3,379 @@ package jiralert
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httputil"
-	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/andygrunwald/go-jira"
 	log "github.com/sirupsen/logrus"
 	"github.com/tixu/jiralert/alertmanager"
 	"github.com/trivago/tgo/tcontainer"
-	bolt "go.etcd.io/bbolt"
+	"go.uber.org/multierr"
 )
 
-//github.com/andygrunwald/go-jira
+// github.com/andygrunwald/go-jira
 // Receiver wraps a JIRA client corresponding to a specific Alertmanager receiver, with its configuration and templates.
 type Receiver struct {
-	conf   *ReceiverConfig
-	tmpl   *Template
-	client *jira.Client
-	dbFile  string
+	conf     *ReceiverConfig
+	tmpl     *Template
+	client   *jira.Client
+	endpoint *Endpoint
+	store    IssueStore
+	state    StateStore
 }
 
+// issueLocks serializes the processing of each issue across notifications and receivers, so that concurrent
+// notifications for the same alerts never create the same issue twice.
+var issueLocks = newKeyLock()
+
 type StatusNotify struct {
 	Status int
 	Err    error
+	// Unprocessed is set when the notification was cancelled, or its deadline expired, before the alert group was
+	// handled.
+	Unprocessed bool
+	// Alerts are the alerts of the group.
+	Alerts alertmanager.Alerts
 }
 
 type Notifier interface {
 	Notify(data *alertmanager.Data) map[string]StatusNotify
 }
 
-// NewReceiver creates a Receiver using the provided configuration and template.
-func NewReceiver(context context.Context, a *APIConfig, c *ReceiverConfig, t *Template, file string) (*Receiver, error) {
-	client, err := jira.NewClient(http.DefaultClient, a.URL)
-	if err != nil {
-		return nil, err
-	}
-	
-	
-	client.Authentication.SetBasicAuth(a.User, string(a.Password))
-
-	return &Receiver{conf: c, tmpl: t, client: client,dbFile: file}, nil
+// NewReceiver creates a Receiver using the provided endpoint, configuration and template. The issue and state stores
+// are usually shared by all receivers.
+func NewReceiver(context context.Context, e *Endpoint, c *ReceiverConfig, t *Template, store IssueStore, state StateStore) (*Receiver, error) {
+	return &Receiver{conf: c, tmpl: t, client: e.client, endpoint: e, store: store, state: state}, nil
 }
-func(r  *Receiver) shutDown (){
-	
+
+func (r *Receiver) shutDown() {
+
 }
 
-// Notify implements the Notifier interface.
-func (r *Receiver) Notify(context context.Context, data *alertmanager.Data) (map[string]StatusNotify, error) {
-	
-	var m map[string]StatusNotify = make(map[string]StatusNotify)
-	project := r.tmpl.Execute(r.conf.Project, data)
-	// check errors from r.tmpl.Execute()
-	if r.tmpl.err != nil {
-		return nil, r.tmpl.err
+// Notify implements the Notifier interface. Up to Parallelism alert groups are processed concurrently. The JIRA
+// requests are bound to ctx, limited by the receiver's NotifyTimeout. When ctx is done, the remaining alert groups are
+// not processed and are reported as such in the returned map.
+func (r *Receiver) Notify(ctx context.Context, data *alertmanager.Data) (map[string]StatusNotify, error) {
+	if r.conf.NotifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.conf.NotifyTimeout)
+		defer cancel()
 	}
-	log.Infof("looping on the alerts from the group")
-	// multipeErrors will be used to stock errors
-	// occuring while iterating on alarms
 
-	for _, alert := range data.Alerts {
-		// Looks like an ALERT metric name, with spaces removed.
-		issueLabel := toIssueLabel(alert.Labels)
-		issue, err := r.getIssue(issueLabel, project)
-		if err != nil {
-			log.Warnf("got an error while searching %s", err)
-			m[issueLabel] = StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	var (
+		m    = make(map[string]StatusNotify)
+		mu   sync.Mutex // guards m
+		wg   sync.WaitGroup
+		work = make(chan *alertGroup)
+	)
+	project, err := r.tmpl.Render("project", r.conf.Project, data)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("looping on the issue groups from the alert group")
+
+	for i := 0; i < r.conf.parallelism(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range work {
+				status := r.processGroup(ctx, project, data, group)
+				status.Alerts = group.alerts
+				mu.Lock()
+				m[group.label] = status
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, group := range r.groupAlerts(data) {
+		if len(group.alerts.Firing()) == 0 && r.conf.ResolveState == "" {
+			log.Infof("Alerts for %s are resolved and no resolve state is configured, ignoring", group.label)
 			continue
 		}
+		work <- group
+	}
+	close(work)
+	wg.Wait()
 
-		if issue != nil {
-			r.addComment(issue, r.tmpl.Execute(r.conf.Comment, alert))
-			// The set of JIRA status categories is fixed, this is a safe check to make.
-			if issue.Fields.Status.StatusCategory.Key != "done" {
-				// Issue is in a "to do" or "in progress" state, all done here.
-				log.Infof("Issue %s for %s is unresolved, nothing to do", issue.Key, issueLabel)
-				// nothing to be done on this issues
-				m[issueLabel] = StatusNotify{Status: http.StatusOK, Err: nil}
-				continue
-			}
-			if r.conf.WontFixResolution != "" && issue.Fields.Resolution != nil &&
-				issue.Fields.Resolution.Name == r.conf.WontFixResolution {
-				// Issue is resolved as "Won't Fix" or equivalent, log a message just in case.
-				log.Infof("Issue %s for %s is resolved as %q, not reopening", issue.Key, issueLabel, issue.Fields.Resolution.Name)
-				// nothing to be done on this issues
-				continue
-			}
-			log.Infof("Issue %s for %s was resolved, reopening", issue.Key, issueLabel)
-			if err := r.reopen(issue.Key); err != nil {
-				m[issueLabel] = StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	return m, nil
 
-			}
-			m[issueLabel] = StatusNotify{Status: http.StatusOK, Err: nil}
-			continue
+}
+
+// processGroup notifies the alert group while holding the lock of its issue.
+func (r *Receiver) processGroup(ctx context.Context, project string, data *alertmanager.Data, group *alertGroup) StatusNotify {
+	lockKey := r.endpoint.conf.URL + "\x00" + project + "\x00" + group.label
+	if err := issueLocks.Lock(ctx, lockKey); err != nil {
+		log.Warnf("Alerts for %s not processed: %s", group.label, err)
+		return StatusNotify{Status: http.StatusGatewayTimeout, Err: fmt.Errorf("not processed: %s", err), Unprocessed: true}
+	}
+	defer issueLocks.Unlock(lockKey)
+
+	status := r.notifyGroup(ctx, project, data, group)
+	if status.Status != http.StatusOK {
+		if ctx.Err() != nil {
+			status.Status = http.StatusGatewayTimeout
+		} else if r.endpoint.CircuitOpen() {
+			status.Status = http.StatusServiceUnavailable
 		}
+	}
+	return status
+}
 
-		log.Infof("No issue matching %s found, creating new issue", issueLabel)
+// alertGroup is a set of alerts sharing a single JIRA issue, identified by the issue label.
+type alertGroup struct {
+	label  string
+	alerts alertmanager.Alerts
+	// data is passed to the summary, description, comment and fields templates: the alert itself when grouping by
+	// alert, an alertmanager.Data restricted to the alerts of the group otherwise.
+	data interface{}
+	// note is appended to the description and comments, it tells when Alertmanager truncated the notification.
+	note string
+}
 
-		issue = &jira.Issue{
-			Fields: &jira.IssueFields{
-				Project:     jira.Project{Key: project},
-				Type:        jira.IssueType{Name: r.tmpl.Execute(r.conf.IssueType, data)},
-				Description: r.tmpl.Execute(r.conf.Description, alert),
-				Summary:     r.tmpl.Execute(r.conf.Summary, alert),
-				Labels: []string{
-					issueLabel,
-				},
+// groupAlerts splits the alerts of the notification into alert groups according to the receiver's GroupBy setting.
+func (r *Receiver) groupAlerts(data *alertmanager.Data) []*alertGroup {
+	var (
+		groups  []*alertGroup
+		byLabel = map[string]*alertGroup{}
+		mode    = r.conf.groupByMode()
+	)
+	for _, alert := range data.Alerts {
+		var labels alertmanager.KV
+		switch mode {
+		case GroupByAlert:
+			labels = alert.Labels
+		case GroupByGroup:
+			labels = data.GroupLabels
+		default:
+			labels = alert.Labels.Only(r.conf.GroupBy)
+		}
+		label := r.issueLabel(data, alert, labels)
+		group, ok := byLabel[label]
+		if !ok {
+			group = &alertGroup{label: label}
+			byLabel[label] = group
+			groups = append(groups, group)
+		}
+		group.alerts = append(group.alerts, alert)
+	}
 
-				Unknowns: tcontainer.NewMarshalMap(),
-			},
+	if data.TruncatedAlerts > 0 {
+		log.Warnf("Alertmanager truncated %d alerts of the notification to %s", data.TruncatedAlerts, data.Receiver)
+	}
+	for _, group := range groups {
+		if data.TruncatedAlerts > 0 {
+			group.note = fmt.Sprintf("\n\n%d more alerts were truncated by Alertmanager, see %s for the complete list.",
+				data.TruncatedAlerts, data.ExternalURL)
 		}
-		log.Printf("issue.field %+v", issue.Fields)
-		if r.conf.Priority != "" {
-			issue.Fields.Priority = &jira.Priority{Name: r.conf.Priority}
+		switch mode {
+		case GroupByAlert:
+			group.data = group.alerts[0]
+		case GroupByGroup:
+			groupData := *data
+			groupData.Alerts = group.alerts
+			group.data = &groupData
+		default:
+			groupData := *data
+			groupData.Alerts = group.alerts
+			groupData.GroupLabels = group.alerts[0].Labels.Only(r.conf.GroupBy)
+			groupData.CommonLabels = commonKV(group.alerts, func(a alertmanager.Alert) alertmanager.KV { return a.Labels })
+			groupData.CommonAnnotations = commonKV(group.alerts, func(a alertmanager.Alert) alertmanager.KV { return a.Annotations })
+			group.data = &groupData
 		}
+	}
+	return groups
+}
 
-		// Add Components
-		if len(r.conf.Components) > 0 {
-			issue.Fields.Components = make([]*jira.Component, 0, len(r.conf.Components))
-			for _, component := range r.conf.Components {
-				issue.Fields.Components = append(issue.Fields.Components, &jira.Component{Name: component})
-			}
+// issueLabel returns the JIRA label identifying the issue of the alert, according to the receiver's DedupBy setting.
+// labels are the alert labels selected by the GroupBy setting. The group key is hashed, it is too long for a JIRA
+// label. The labels are used when the notification lacks the fingerprint or group key, e.g. from an older Alertmanager.
+func (r *Receiver) issueLabel(data *alertmanager.Data, alert alertmanager.Alert, labels alertmanager.KV) string {
+	switch r.conf.DedupBy {
+	case DedupByFingerprint:
+		if alert.Fingerprint != "" {
+			return toIssueLabel(alertmanager.KV{"fingerprint": alert.Fingerprint})
 		}
+	case DedupByGroupKey:
+		if data.GroupKey != "" {
+			sum := sha256.Sum256([]byte(data.GroupKey))
+			return toIssueLabel(alertmanager.KV{"groupkey": hex.EncodeToString(sum[:8])})
+		}
+	}
+	return toIssueLabel(labels)
+}
 
-		// Add Labels
-		if r.conf.AddGroupLabels {
-			for k, v := range data.GroupLabels {
-				issue.Fields.Labels = append(issue.Fields.Labels, fmt.Sprintf("%s=%q", k, v))
+// commonKV returns the key/value pairs shared by all alerts, as extracted by kv.
+func commonKV(alerts alertmanager.Alerts, kv func(alertmanager.Alert) alertmanager.KV) alertmanager.KV {
+	common := alertmanager.KV{}
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range kv(alerts[0]) {
+		common[k] = v
+	}
+	for _, alert := range alerts[1:] {
+		values := kv(alert)
+		for k, v := range common {
+			if values[k] != v {
+				delete(common, k)
 			}
 		}
+	}
+	return common
+}
 
-		// check errors from r.tmpl.Execute()
-		if r.tmpl.err != nil {
-			m[issueLabel] = StatusNotify{Status: http.StatusInternalServerError, Err: r.tmpl.err}
-			continue
+// notifyGroup creates, reopens, comments or resolves the issue matching the alert group.
+func (r *Receiver) notifyGroup(ctx context.Context, project string, data *alertmanager.Data, group *alertGroup) StatusNotify {
+	issueLabel := group.label
+	issue, err := r.getIssue(ctx, issueLabel, project)
+	if err != nil {
+		log.Warnf("got an error while searching %s", err)
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+
+	if len(group.alerts.Firing()) == 0 {
+		return r.resolve(ctx, issue, group)
+	}
+
+	if issue != nil {
+		// The set of JIRA status categories is fixed, this is a safe check to make.
+		resolved := issue.Fields.Status.StatusCategory.Key == "done"
+		wontFix := r.conf.WontFixResolution != "" && issue.Fields.Resolution != nil &&
+			issue.Fields.Resolution.Name == r.conf.WontFixResolution
+		// Reopening is always commented on, whatever the comment mode.
+		if err := r.comment(ctx, issue, group, resolved && !wontFix); err != nil {
+			return StatusNotify{Status: http.StatusInternalServerError, Err: err}
 		}
-		issue, err = r.create(issue)
-		log.Infof("issue %+v", issue)
-		if err != nil {
-			m[issueLabel] = StatusNotify{Status: http.StatusInternalServerError, Err: err}
-			continue
+		if !wontFix {
+			if err := r.updateFields(ctx, issue, group); err != nil {
+				return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+			}
+		}
+		if !resolved {
+			// Issue is in a "to do" or "in progress" state, all done here.
+			log.Infof("Issue %s for %s is unresolved, nothing to do", issue.Key, issueLabel)
+			// nothing to be done on this issues
+			return StatusNotify{Status: http.StatusOK, Err: nil}
+		}
+		if wontFix {
+			// Issue is resolved as "Won't Fix" or equivalent, log a message just in case.
+			log.Infof("Issue %s for %s is resolved as %q, not reopening", issue.Key, issueLabel, issue.Fields.Resolution.Name)
+			// nothing to be done on this issues
+			return StatusNotify{Status: http.StatusOK, Err: nil}
 		}
-		m[issueLabel] = StatusNotify{Status: http.StatusOK, Err: nil}
-		log.Infof("Issue created: key=%s ID=%s", issue.Key, issue.ID)
+		log.Infof("Issue %s for %s was resolved, reopening", issue.Key, issueLabel)
+		if err := r.reopen(ctx, issue.Key); err != nil {
+			return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+		}
+		return StatusNotify{Status: http.StatusOK, Err: nil}
+	}
 
+	var (
+		reserved bool
+		start    time.Time
+	)
+	if r.conf.MaxIssuesPerWindow > 0 {
+		var allowed bool
+		allowed, start, err = r.reserveIssue(ctx)
+		if err != nil {
+			return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+		}
+		if !allowed {
+			return r.foldIntoStorm(ctx, project, data, group, start)
+		}
+		reserved = true
 	}
 
-	return m, nil
+	log.Infof("No issue matching %s found, creating new issue", issueLabel)
 
+	issue, err = r.renderIssue(project, data, group)
+	if err != nil {
+		err = fmt.Errorf("issue %s: %s", issueLabel, err)
+	} else {
+		issue, err = r.create(ctx, project, issue)
+	}
+	if err != nil {
+		if reserved {
+			r.releaseIssue(start)
+		}
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+	log.Infof("Issue created: key=%s ID=%s", issue.Key, issue.ID)
+	if len(r.conf.UpdateFields) > 0 {
+		// Later notifications only update the fields whose rendered value changes.
+		if fields, err := r.renderUpdateFields(group); err == nil {
+			r.saveFieldHashes(ctx, issue, fieldHashes(fields))
+		}
+	}
+	if err := r.store.Put(ctx, issueLabel, issue.ID); err != nil {
+		log.Warnf("got an error while updating the issue store %s", err)
+	}
+	return StatusNotify{Status: http.StatusOK, Err: nil}
 }
 
-// deepCopyWithTemplate returns a deep copy of a map/slice/array/string/int/bool or combination thereof, executing the
-// provided template (with the provided data) on all string keys or values. All maps are connverted to
-// map[string]interface{}, with all non-string keys discarded.
-func deepCopyWithTemplate(value interface{}, tmpl *Template, data interface{}) interface{} {
-	if value == nil {
-		return value
-	}
+// renderIssue renders the issue to create for the alert group. The returned error lists all the fields whose template
+// failed.
+func (r *Receiver) renderIssue(project string, data *alertmanager.Data, group *alertGroup) (*jira.Issue, error) {
+	rd := &renderer{tmpl: r.tmpl, data: group.data}
+	issue := r.newIssue(project, data, group.label, rd)
+	issue.Fields.Summary = rd.render("summary", r.conf.Summary)
+	r.setDescription(issue, rd.render("description", r.conf.Description)+group.note)
+	log.Printf("issue.field %+v", issue.Fields)
+	return issue, rd.err
+}
 
-	valueMeta := reflect.ValueOf(value)
-	switch valueMeta.Kind() {
+// newIssue renders the fields shared by all the issues the receiver creates: project, issue type, priority,
+// components, labels and custom fields. The issue type is rendered with the notification, the other templates with rd.
+func (r *Receiver) newIssue(project string, data *alertmanager.Data, label string, rd *renderer) *jira.Issue {
+	issueType, err := r.tmpl.Render("issuetype", r.conf.IssueType, data)
+	rd.err = multierr.Append(rd.err, err)
+	issue := &jira.Issue{
+		Fields: &jira.IssueFields{
+			Project: jira.Project{Key: project},
+			Type:    jira.IssueType{Name: issueType},
+			Labels: []string{
+				label,
+			},
 
-	case reflect.String:
-		return tmpl.Execute(value.(string), data)
+			Unknowns: tcontainer.NewMarshalMap(),
+		},
+	}
+	if r.conf.Priority != "" {
+		issue.Fields.Priority = &jira.Priority{Name: rd.render("priority", r.conf.Priority)}
+	}
 
-	case reflect.Array, reflect.Slice:
-		arrayLen := valueMeta.Len()
-		converted := make([]interface{}, arrayLen)
-		for i := 0; i < arrayLen; i++ {
-			converted[i] = deepCopyWithTemplate(valueMeta.Index(i).Interface(), tmpl, data)
+	// Add Components
+	if len(r.conf.Components) > 0 {
+		issue.Fields.Components = make([]*jira.Component, 0, len(r.conf.Components))
+		for _, component := range r.conf.Components {
+			issue.Fields.Components = append(issue.Fields.Components, &jira.Component{Name: component})
 		}
-		return converted
-
-	case reflect.Map:
-		keys := valueMeta.MapKeys()
-		converted := make(map[string]interface{}, len(keys))
+	}
 
-		for _, keyMeta := range keys {
-			strKey, isString := keyMeta.Interface().(string)
-			if !isString {
-				continue
-			}
-			strKey = tmpl.Execute(strKey, data)
-			converted[strKey] = deepCopyWithTemplate(valueMeta.MapIndex(keyMeta).Interface(), tmpl, data)
+	// Add Labels
+	if r.conf.AddGroupLabels {
+		for k, v := range data.GroupLabels {
+			issue.Fields.Labels = append(issue.Fields.Labels, fmt.Sprintf("%s=%q", k, v))
 		}
-		return converted
+	}
 
-	default:
-		return value
+	// Add custom fields
+	if len(r.conf.Fields) > 0 {
+		fields := mapStrings(r.conf.Fields, "fields", rd.render).(map[string]interface{})
+		for id, value := range fields {
+			issue.Fields.Unknowns[id] = value
+		}
 	}
+	return issue
 }
 
 // toIssueLabel returns the group labels in the form of an ALERT metric name, with all spaces removed.
@@ -203,20 +385,22 @@ func toIssueLabel(groupLabels alertmanager.KV) string {
 		buf.WriteString(p.Name)
 		buf.WriteString(fmt.Sprintf("=%q,", p.Value))
 	}
-	buf.Truncate(buf.Len() - 1)
+	if len(groupLabels) > 0 {
+		buf.Truncate(buf.Len() - 1)
+	}
 	buf.WriteString("}")
 	return strings.Replace(buf.String(), " ", "", -1)
 }
 
-func (r *Receiver) search(project, issueLabel string) (*jira.Issue, error) {
+func (r *Receiver) search(ctx context.Context, project, issueLabel string) (*jira.Issue, error) {
 
 	query := fmt.Sprintf("project=%s and labels=%q order by key", project, issueLabel)
 	options := &jira.SearchOptions{
-		Fields:     []string{"summary", "status", "resolution"},
+		Fields:     r.issueFields(),
 		MaxResults: 50,
 	}
 	log.Infof("search: query=%v options=%+v", query, options)
-	issues, resp, err := r.client.Issue.Search(query, options)
+	issues, resp, err := r.searchIssues(ctx, query, options)
 	if err != nil {
 		err := handleJiraError("Issue.Search", resp, err)
 		return nil, err
@@ -232,21 +416,131 @@ func (r *Receiver) search(project, issueLabel string) (*jira.Issue, error) {
 	log.Infof("  no results")
 	return nil, nil
 }
-func (r *Receiver) addComment(issue *jira.Issue, commentstring string) error {
-	comment := &jira.Comment{Body: commentstring}
-	comment, _, err := r.client.Issue.AddComment(issue.ID, comment)
-	return err
 
+// commentState is the last comment posted on an issue, kept in the StateStore.
+type commentState struct {
+	Hash string
+	Time time.Time
+}
+
+// comment posts the rendered comment of the alert group on the issue, unless the receiver's CommentMode skips it:
+// CommentOnChange skips comments identical to the last one, for alerts with the same annotations, CommentInterval
+// skips comments until CommentInterval has elapsed since the last one. force posts the comment anyway.
+func (r *Receiver) comment(ctx context.Context, issue *jira.Issue, group *alertGroup, force bool) error {
+	if r.conf.Comment == "" {
+		return nil
+	}
+	text, err := r.tmpl.Render("comment", r.conf.Comment, group.data)
+	if err != nil {
+		return err
+	}
+	text += group.note
+
+	key := "comment/" + issue.ID
+	hash := commentHash(text, group.alerts)
+	var last commentState
+	if value, err := r.state.Get(ctx, key); err != nil {
+		log.Warnf("got an error while reading the state store %s", err)
+	} else if value != nil {
+		if err := json.Unmarshal(value, &last); err != nil {
+			log.Warnf("ignoring invalid comment state of issue %s: %s", issue.Key, err)
+		}
+	}
+	if !force {
+		switch r.conf.CommentMode {
+		case CommentOnChange:
+			if hash == last.Hash {
+				log.Infof("Comment on issue %s unchanged, not commenting", issue.Key)
+				return nil
+			}
+		case CommentInterval:
+			if time.Since(last.Time) < r.conf.CommentInterval {
+				log.Infof("Issue %s commented on at %s, not commenting", issue.Key, last.Time)
+				return nil
+			}
+		}
+	}
+
+	if err := r.addComment(ctx, issue, text); err != nil {
+		return err
+	}
+	value, err := json.Marshal(commentState{Hash: hash, Time: time.Now()})
+	if err == nil {
+		err = r.state.Update(ctx, key, func([]byte) ([]byte, error) { return value, nil })
+	}
+	if err != nil {
+		log.Warnf("got an error while updating the state store %s", err)
+	}
+	return nil
+}
+
+// commentHash returns a hash of the comment text and of the annotations of the alerts.
+func commentHash(text string, alerts alertmanager.Alerts) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q\n", text)
+	for _, alert := range alerts {
+		for _, p := range alert.Annotations.SortedPairs() {
+			fmt.Fprintf(h, "%q=%q,", p.Name, p.Value)
+		}
+		fmt.Fprintln(h)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *Receiver) addComment(ctx context.Context, issue *jira.Issue, commentstring string) error {
+	resp, err := r.addIssueComment(ctx, issue.ID, r.formatText(commentstring))
+	if err != nil {
+		return handleJiraError("Issue.AddComment", resp, err)
+	}
+	return nil
+
+}
+
+// resolve comments on the issue matching a resolved alert group and transitions it into the configured resolve state.
+func (r *Receiver) resolve(ctx context.Context, issue *jira.Issue, group *alertGroup) StatusNotify {
+	issueLabel := group.label
+	if issue == nil {
+		log.Infof("No issue matching %s found, nothing to resolve", issueLabel)
+		return StatusNotify{Status: http.StatusOK, Err: nil}
+	}
+	resolved := issue.Fields.Status.StatusCategory.Key == "done"
+	// Resolving is always commented on, whatever the comment mode.
+	if err := r.comment(ctx, issue, group, !resolved); err != nil {
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+	if resolved {
+		log.Infof("Issue %s for %s is already resolved, nothing to do", issue.Key, issueLabel)
+		return StatusNotify{Status: http.StatusOK, Err: nil}
+	}
+	log.Infof("Alert %s was resolved, resolving issue %s", issueLabel, issue.Key)
+	if err := r.transition(ctx, issue.Key, r.conf.ResolveState, r.conf.ResolveResolution); err != nil {
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+	return StatusNotify{Status: http.StatusOK, Err: nil}
 }
-func (r *Receiver) reopen(issueKey string) error {
-	transitions, resp, err := r.client.Issue.GetTransitions(issueKey)
+
+func (r *Receiver) reopen(ctx context.Context, issueKey string) error {
+	return r.transition(ctx, issueKey, r.conf.ReopenState, "")
+}
+
+// transition moves the issue into the given state, setting the resolution field when it is not empty.
+func (r *Receiver) transition(ctx context.Context, issueKey, state, resolution string) error {
+	transitions, resp, err := r.getTransitions(ctx, issueKey)
 	if err != nil {
 		return handleJiraError("Issue.GetTransitions", resp, err)
 	}
 	for _, t := range transitions {
-		if t.Name == r.conf.ReopenState {
-			log.Infof("reopen: issueKey=%v transitionID=%v", issueKey, t.ID)
-			resp, err = r.client.Issue.DoTransition(issueKey, t.ID)
+		if t.Name == state {
+			log.Infof("transition: issueKey=%v transitionID=%v resolution=%q", issueKey, t.ID, resolution)
+			payload := map[string]interface{}{
+				"transition": jira.TransitionPayload{ID: t.ID},
+			}
+			if resolution != "" {
+				payload["fields"] = map[string]interface{}{
+					"resolution": map[string]string{"name": resolution},
+				}
+			}
+			resp, err = r.doTransition(ctx, issueKey, payload)
 			if err != nil {
 				return handleJiraError("Issue.DoTransition", resp, err)
 			}
@@ -254,12 +548,111 @@ func (r *Receiver) reopen(issueKey string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf("JIRA state %q does not exist or no transition possible for %s", r.conf.ReopenState, issueKey)
+	return fmt.Errorf("JIRA state %q does not exist or no transition possible for %s", state, issueKey)
 }
 
-func (r *Receiver) create(issue *jira.Issue) (*jira.Issue, error) {
+// checkFields verifies that every field ID is available on the create screen of the given project and issue type. On
+// REST API v3 endpoints, it also replaces the users named in the user fields by their account ID.
+func (r *Receiver) checkFields(ctx context.Context, project, issueType string, fields map[string]interface{}) error {
+	metaIssueType, err := r.issueTypeMeta(ctx, project, issueType)
+	if err != nil {
+		return err
+	}
+
+	var unknown []string
+	for id := range fields {
+		// ADF descriptions are set in the unknown fields, next to the custom fields.
+		if _, ok := metaIssueType.Fields[id]; !ok && id != FieldDescription {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown JIRA fields %s for issue type %q in project %q, check the field IDs in the receiver configuration",
+			strings.Join(unknown, ", "), issueType, project)
+	}
+	if r.apiVersion() == APIVersion3 {
+		return r.resolveUsers(ctx, metaIssueType, fields)
+	}
+	return nil
+}
+
+// issueTypeMeta returns the create metadata of the issue type in the project. It is cached by the endpoint, so it is
+// requested once per configuration load.
+func (r *Receiver) issueTypeMeta(ctx context.Context, project, issueType string) (*jira.MetaIssueType, error) {
+	key := project + "\x00" + issueType
+	if cached, ok := r.endpoint.createMeta.Load(key); ok {
+		return cached.(*jira.MetaIssueType), nil
+	}
+	meta, resp, err := r.getCreateMeta(ctx, project)
+	if err != nil {
+		return nil, handleJiraError("Issue.GetCreateMeta", resp, err)
+	}
+	metaProject := meta.GetProjectWithKey(project)
+	if metaProject == nil {
+		return nil, fmt.Errorf("JIRA project %q not found or not visible to the JIRA user", project)
+	}
+	metaIssueType := metaProject.GetIssueTypeWithName(issueType)
+	if metaIssueType == nil {
+		return nil, fmt.Errorf("JIRA issue type %q does not exist in project %q", issueType, project)
+	}
+	r.endpoint.createMeta.Store(key, metaIssueType)
+	return metaIssueType, nil
+}
+
+// issueFields returns the fields of the existing issues read by the receiver. The description is not read from the
+// REST API v3, which returns it as an ADF document.
+func (r *Receiver) issueFields() []string {
+	fields := []string{"project", "issuetype", "summary", "priority", "status", "resolution"}
+	if r.apiVersion() != APIVersion3 {
+		fields = append(fields, FieldDescription)
+	}
+	for _, name := range r.conf.UpdateFields {
+		if name != FieldSummary && name != FieldDescription && name != FieldPriority {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// formatText converts the rendered description or comment to the receiver's TextFormat. It returns a string, or an
+// *ADFNode for TextFormatADF and on REST API v3 endpoints.
+func (r *Receiver) formatText(text string) interface{} {
+	switch {
+	case r.conf.TextFormat == TextFormatWiki:
+		return ToJiraWiki(text)
+	case r.conf.TextFormat == TextFormatADF:
+		return ToADF(text)
+	case r.apiVersion() == APIVersion3:
+		return PlainToADF(text)
+	}
+	return text
+}
+
+// setDescription sets the description of the issue to create, converted to the receiver's TextFormat. ADF documents
+// are not strings, they replace the description in the unknown fields.
+func (r *Receiver) setDescription(issue *jira.Issue, text string) {
+	switch description := r.formatText(text).(type) {
+	case string:
+		issue.Fields.Description = description
+	default:
+		if issue.Fields.Unknowns == nil {
+			issue.Fields.Unknowns = tcontainer.NewMarshalMap()
+		}
+		issue.Fields.Unknowns[FieldDescription] = description
+	}
+}
+
+// create creates the issue in the project, after checking its custom fields with checkFields. An ADF description alone
+// in the unknown fields is not checked.
+func (r *Receiver) create(ctx context.Context, project string, issue *jira.Issue) (*jira.Issue, error) {
+	if fields := map[string]interface{}(issue.Fields.Unknowns); hasCustomFields(fields) {
+		if err := r.checkFields(ctx, project, issue.Fields.Type.Name, fields); err != nil {
+			return nil, err
+		}
+	}
 	log.Infof("create: issue=%+v", *issue)
-	issue, resp, err := r.client.Issue.Create(issue)
+	issue, resp, err := r.createIssue(ctx, issue)
 	if err != nil {
 		return nil, handleJiraError("Issue.Create", resp, err)
 	}
@@ -268,72 +661,43 @@ func (r *Receiver) create(issue *jira.Issue) (*jira.Issue, error) {
 	return issue, nil
 }
 
-func (r *Receiver) getIssue(issueLabel, project string) (*jira.Issue, error) {
-	db, err := bolt.Open(r.dbFile, 0600, nil)
-	
-	
-	
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-	
+// getIssue returns the issue matching the label, looking it up by the ID cached in the store first and searching for
+// it if that fails. It returns nil if no issue exists.
+func (r *Receiver) getIssue(ctx context.Context, issueLabel, project string) (*jira.Issue, error) {
 	log.Infof("getting   issue with label : %s", issueLabel)
-	var id string
-	
-	err = db.View(func(tx *bolt.Tx) error {
-		bk := tx.Bucket([]byte("JIRA"))
-		bs := bk.Get([]byte(issueLabel))
-		if bs == nil {
-			return fmt.Errorf("issue not found locally")
-		}
-		id = string(bs)
-		log.Infof("local ID is %s", id)
-		return nil
-	})
+	id, err := r.store.Get(ctx, issueLabel)
+	if err != nil && err != ErrIssueNotFound {
+		log.Warnf("got an error while reading the issue store %s", err)
+	}
 
-	if len(id) == 0 {
-		// we did not find anything
-		issue, err := r.search(project, issueLabel)
-		if err != nil {
-			log.Warnf("got an error while searching %s", err)
-			return nil, err
+	if len(id) > 0 {
+		log.Infof("local ID is %s", id)
+		issue, _, err := r.getIssueByID(ctx, id, r.issueFields())
+		if err == nil {
+			return issue, nil
 		}
-		if issue == nil {
-			return nil, nil
-		
-			}	// we found something
-		err = db.Update(func(tx *bolt.Tx) error {
-			bk := tx.Bucket([]byte("JIRA"))
-			return bk.Put([]byte(issueLabel), []byte(issue.ID))
-
-		})
-		//we return the issue after updating the db
-		return issue, nil
+		log.Infof("got an error while getting the issue by id %s", err)
 	}
 
-	issue, _, err := r.client.Issue.Get(id, nil)
+	issue, err := r.search(ctx, project, issueLabel)
 	if err != nil {
-		log.Infof("got an error while getting the issue by id %s", err )
-		issue, err := r.search(project, issueLabel)
-		if err != nil {
-			log.Warnf("got an error while searching %s", err)
-			return nil, err
-		}
-		if issue != nil {// we found something
-		err = db.Update(func(tx *bolt.Tx) error {
-			bk, err := tx.CreateBucketIfNotExists([]byte("JIRA"))
-			if err != nil {
-				return err
+		log.Warnf("got an error while searching %s", err)
+		return nil, err
+	}
+	if issue == nil {
+		if len(id) > 0 {
+			// The cached issue is gone.
+			if err := r.store.Delete(ctx, issueLabel); err != nil {
+				log.Warnf("got an error while updating the issue store %s", err)
 			}
-			return bk.Put([]byte(issueLabel), []byte(issue.ID))
-		})
+		}
+		return nil, nil
 	}
-		//we return the issue after updating the db
-		return issue, nil
+	// we found something, we return the issue after updating the store
+	if err := r.store.Put(ctx, issueLabel, issue.ID); err != nil {
+		log.Warnf("got an error while updating the issue store %s", err)
 	}
 	return issue, nil
-
 }
 
 func handleJiraError(api string, resp *jira.Response, err error) error {
@@ -0,0 +1,422 @@
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tixu/jiralert/alertmanager"
+)
+
+// resolved returns the alert, resolved.
+func resolved(alert alertmanager.Alert) alertmanager.Alert {
+	alert.Status = alertmanager.AlertResolved
+	return alert
+}
+
+// issueLabels returns the labels of the issues, in creation order.
+func issueLabels(issues []fakeIssue) [][]interface{} {
+	labels := make([][]interface{}, len(issues))
+	for i, issue := range issues {
+		labels[i] = issue.Fields["labels"].([]interface{})
+	}
+	return labels
+}
+
+func TestNotifyGroupBy(t *testing.T) {
+	var (
+		a1 = testAlert("alertname", "A", "service", "api", "instance", "1")
+		a2 = testAlert("alertname", "A", "service", "api", "instance", "2")
+		a3 = testAlert("alertname", "A", "service", "db", "instance", "3")
+	)
+	for _, tc := range []struct {
+		name    string
+		groupBy []string
+		dedupBy string
+		want    []string // labels of the issues created
+	}{
+		{
+			name: "alert",
+			want: []string{toIssueLabel(a1.Labels), toIssueLabel(a2.Labels), toIssueLabel(a3.Labels)},
+		},
+		{
+			name:    "group",
+			groupBy: []string{GroupByGroup},
+			want:    []string{toIssueLabel(alertmanager.KV{"alertname": "A"})},
+		},
+		{
+			name:    "labels",
+			groupBy: []string{"service"},
+			want:    []string{toIssueLabel(alertmanager.KV{"service": "api"}), toIssueLabel(alertmanager.KV{"service": "db"})},
+		},
+		{
+			name:    "fingerprint",
+			dedupBy: DedupByFingerprint,
+			want: []string{
+				toIssueLabel(alertmanager.KV{"fingerprint": a1.Fingerprint}),
+				toIssueLabel(alertmanager.KV{"fingerprint": a2.Fingerprint}),
+				toIssueLabel(alertmanager.KV{"fingerprint": a3.Fingerprint}),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFakeJira(t)
+			conf := &ReceiverConfig{GroupBy: tc.groupBy, DedupBy: tc.dedupBy, Comment: "{{ len .Alerts }}"}
+			if tc.groupBy == nil {
+				conf.Comment = "{{ .Status }}"
+			}
+			data := testData([]string{"alertname"}, a1, a2, a3)
+			notify(t, testReceiver(t, f, conf), data)
+
+			issues := f.Issues()
+			if len(issues) != len(tc.want) {
+				t.Fatalf("got issues with labels %v, want %v", issueLabels(issues), tc.want)
+			}
+			for i, want := range tc.want {
+				if labels := issues[i].Fields["labels"].([]interface{}); len(labels) != 1 || labels[0] != want {
+					t.Errorf("issue %d has labels %v, want %q", i, labels, want)
+				}
+			}
+
+			// A receiver with an empty issue store finds the issues by searching their label.
+			searches := f.Requests("/search")
+			notify(t, testReceiver(t, f, conf), data)
+			if n := len(f.Issues()); n != len(tc.want) {
+				t.Errorf("got %d issues after the repeated notification, want %d", n, len(tc.want))
+			}
+			if n := f.Requests("/search") - searches; n != len(tc.want) {
+				t.Errorf("got %d searches, want one per issue", n)
+			}
+			for i, issue := range f.Issues() {
+				if len(issue.Comments) != 1 {
+					t.Errorf("issue %d has comments %v, want the repeated notification commented", i, issue.Comments)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyResolveReopen(t *testing.T) {
+	f := newFakeJira(t)
+	r := testReceiver(t, f, &ReceiverConfig{
+		Comment:           "{{ .Status }}",
+		CommentMode:       CommentOnChange,
+		ResolveState:      "Resolve Issue",
+		ResolveResolution: "Done",
+	})
+	alert := testAlert("alertname", "A")
+
+	notify(t, r, testData(nil, alert))
+	notify(t, r, testData(nil, resolved(alert)))
+	issues := f.Issues()
+	if len(issues) != 1 || !issues[0].Done || issues[0].Resolution != "Done" {
+		t.Fatalf("got issues %+v, want the issue resolved as Done", issues)
+	}
+	if c := issues[0].Comments; len(c) != 1 || c[0] != "resolved" {
+		t.Errorf("got comments %v, want the resolution commented", c)
+	}
+
+	// Resolving again changes nothing, the comment is unchanged.
+	notify(t, r, testData(nil, resolved(alert)))
+	if n := f.Requests("POST /rest/api/2/issue/PROJ-1/transitions"); n != 1 {
+		t.Errorf("got %d transitions, want the issue resolved once", n)
+	}
+
+	notify(t, r, testData(nil, alert))
+	issues = f.Issues()
+	if len(issues) != 1 || issues[0].Done {
+		t.Fatalf("got issues %+v, want the issue reopened", issues)
+	}
+	if c := issues[0].Comments; len(c) != 2 || c[1] != "firing" {
+		t.Errorf("got comments %v, want the reopening commented", c)
+	}
+}
+
+func TestNotifyWontFix(t *testing.T) {
+	f := newFakeJira(t)
+	r := testReceiver(t, f, &ReceiverConfig{
+		ResolveState:      "Resolve Issue",
+		ResolveResolution: "Won't Fix",
+		WontFixResolution: "Won't Fix",
+	})
+	alert := testAlert("alertname", "A")
+
+	notify(t, r, testData(nil, alert))
+	notify(t, r, testData(nil, resolved(alert)))
+	notify(t, r, testData(nil, alert))
+	if issues := f.Issues(); len(issues) != 1 || !issues[0].Done {
+		t.Fatalf("got issues %+v, want the issue left resolved", issues)
+	}
+}
+
+func TestNotifyResolvedIgnored(t *testing.T) {
+	f := newFakeJira(t)
+	r := testReceiver(t, f, &ReceiverConfig{})
+	alert := testAlert("alertname", "A")
+
+	notify(t, r, testData(nil, alert))
+	if statuses := notify(t, r, testData(nil, resolved(alert))); len(statuses) != 0 {
+		t.Errorf("got statuses %v, want resolved alerts ignored without a resolve state", statuses)
+	}
+	if issues := f.Issues(); len(issues) != 1 || issues[0].Done {
+		t.Fatalf("got issues %+v, want the issue left open", issues)
+	}
+}
+
+func TestCommentModes(t *testing.T) {
+	annotated := func(summary string) *alertmanager.Data {
+		alert := testAlert("alertname", "A")
+		alert.Annotations = alertmanager.KV{"summary": summary}
+		return testData(nil, alert)
+	}
+
+	t.Run(CommentOnChange, func(t *testing.T) {
+		f := newFakeJira(t)
+		r := testReceiver(t, f, &ReceiverConfig{Comment: "{{ .Status }}", CommentMode: CommentOnChange})
+		for i, step := range []struct {
+			summary  string
+			comments int
+		}{
+			{"disk 80% full", 0},
+			// Nothing was commented yet.
+			{"disk 80% full", 1},
+			{"disk 80% full", 1},
+			{"disk 90% full", 2},
+			{"disk 90% full", 2},
+		} {
+			notify(t, r, annotated(step.summary))
+			if issues := f.Issues(); len(issues) != 1 || len(issues[0].Comments) != step.comments {
+				t.Fatalf("notification %d: got issues %+v, want one issue with %d comments", i, issues, step.comments)
+			}
+		}
+	})
+
+	t.Run(CommentInterval, func(t *testing.T) {
+		f := newFakeJira(t)
+		r := testReceiver(t, f, &ReceiverConfig{Comment: "{{ .Status }}", CommentMode: CommentInterval, CommentInterval: 200 * time.Millisecond})
+		for i, step := range []struct {
+			wait     time.Duration
+			summary  string
+			comments int
+		}{
+			{0, "disk 80% full", 0},
+			{0, "disk 80% full", 1},
+			// Within the interval, even if the annotations changed.
+			{0, "disk 90% full", 1},
+			{250 * time.Millisecond, "disk 90% full", 2},
+			{0, "disk 90% full", 2},
+		} {
+			time.Sleep(step.wait)
+			notify(t, r, annotated(step.summary))
+			if issues := f.Issues(); len(issues) != 1 || len(issues[0].Comments) != step.comments {
+				t.Fatalf("notification %d: got issues %+v, want one issue with %d comments", i, issues, step.comments)
+			}
+		}
+	})
+}
+
+func TestNotifyDuringReload(t *testing.T) {
+	f := newFakeJira(t)
+	f.fields["customfield_10001"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	dir := t.TempDir()
+	tmplFile := filepath.Join(dir, "jiralert.tmpl")
+	if err := ioutil.WriteFile(tmplFile, []byte(`{{ define "jira.summary" }}Alerts {{ .Status }}{{ end }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := fmt.Sprintf(`
+apis:
+  - name: default
+    url: %s
+receivers:
+  - name: test
+    project: %s
+    issuetype: %s
+    summary: '{{ template "jira.summary" . }}'
+    comment: '{{ .Status }}'
+    reopenstate: Reopen Issue
+    parallelism: 2
+    fields:
+      customfield_10001: '{{ .Labels.instance }}'
+template: %s
+`, f.URL, fakeProject, fakeIssueType, tmplFile)
+	if err := ioutil.WriteFile(filepath.Join(dir, "jiralert.yml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot is replaced as a whole on reload, as cmd/jiralert does.
+	type snapshot struct {
+		config    *Config
+		endpoints map[string]*Endpoint
+	}
+	var current atomic.Value
+	load := func() error {
+		config := &Config{}
+		if err := config.ReadConfiguration(dir); err != nil {
+			return err
+		}
+		var previous map[string]*Endpoint
+		if s, ok := current.Load().(*snapshot); ok {
+			previous = s.endpoints
+		}
+		endpoints, err := NewEndpoints(config.APIs, previous)
+		if err != nil {
+			return err
+		}
+		current.Store(&snapshot{config: config, endpoints: endpoints})
+		CloseDroppedEndpoints(previous, endpoints)
+		return nil
+	}
+	if err := load(); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		store = NewMemoryStore()
+		state = newMemStateStore()
+		data  = testData(nil, testAlert("alertname", "A", "instance", "1"), testAlert("alertname", "A", "instance", "2"))
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				s := current.Load().(*snapshot)
+				conf := s.config.ReceiverByName("test")
+				r, err := NewReceiver(context.Background(), s.endpoints[conf.APIName()], conf, s.config.Templates(), store, state)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				statuses, err := r.Notify(context.Background(), data)
+				for label, status := range statuses {
+					if status.Status != http.StatusOK {
+						err = fmt.Errorf("issue %s: status %d: %v", label, status.Status, status.Err)
+					}
+				}
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := load(); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+
+	issues := f.Issues()
+	if len(issues) != 2 {
+		t.Fatalf("got %d issues, want one per alert", len(issues))
+	}
+	for _, issue := range issues {
+		if len(issue.Comments) != 39 {
+			t.Errorf("issue %s has %d comments, want one per notification but the first", issue.Key, len(issue.Comments))
+		}
+	}
+}
+
+func BenchmarkNotify(b *testing.B) {
+	f := newFakeJira(b)
+	conf := &ReceiverConfig{
+		Description: "{{ range .Labels.SortedPairs }}{{ .Name }}={{ .Value }} {{ end }}",
+		Comment:     "{{ .Status }} since {{ .StartsAt }}",
+		CommentMode: CommentOnChange,
+		Fields:      map[string]interface{}{},
+	}
+	for i := 1; i <= 5; i++ {
+		id := fmt.Sprintf("customfield_1000%d", i)
+		f.fields[id] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		conf.Fields[id] = map[string]interface{}{"value": fmt.Sprintf(`{{ .Labels.instance | toUpper }}-%d`, i)}
+		conf.UpdateFields = append(conf.UpdateFields, id)
+	}
+	r := testReceiver(b, f, conf)
+	var alerts alertmanager.Alerts
+	for i := 0; i < 10; i++ {
+		alerts = append(alerts, testAlert("alertname", "A", "instance", strconv.Itoa(i)))
+	}
+	data := testData(nil, alerts...)
+	notify(b, r, data)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		notify(b, r, data)
+	}
+}
+
+func TestNotifyStatusAlerts(t *testing.T) {
+	f := newFakeJira(t)
+	f.failCreates = 1
+	r := testReceiver(t, f, &ReceiverConfig{})
+	a1, a2 := testAlert("alertname", "A"), testAlert("alertname", "B")
+
+	statuses, err := r.Notify(context.Background(), testData(nil, a1, a2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := statuses[toIssueLabel(a1.Labels)]
+	if failed.Status != http.StatusInternalServerError || len(failed.Alerts) != 1 || failed.Alerts[0].Fingerprint != a1.Fingerprint {
+		t.Errorf("got status %+v, want the first alert failed", failed)
+	}
+	if ok := statuses[toIssueLabel(a2.Labels)]; ok.Status != http.StatusOK || len(ok.Alerts) != 1 || ok.Alerts[0].Fingerprint != a2.Fingerprint {
+		t.Errorf("got status %+v, want the second alert notified", ok)
+	}
+}
+
+func TestCreateMetaCached(t *testing.T) {
+	f := newFakeJira(t)
+	f.fields["customfield_10001"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	r := testReceiver(t, f, &ReceiverConfig{Fields: map[string]interface{}{"customfield_10001": "{{ .Labels.instance }}"}})
+
+	notify(t, r, testData(nil, testAlert("alertname", "A", "instance", "1"), testAlert("alertname", "A", "instance", "2")))
+	notify(t, r, testData(nil, testAlert("alertname", "A", "instance", "3")))
+	if n := len(f.Issues()); n != 3 {
+		t.Fatalf("got %d issues, want 3", n)
+	}
+	if n := f.Requests("/issue/createmeta"); n != 1 {
+		t.Errorf("got %d create metadata requests, want 1", n)
+	}
+}
+
+func TestCreateADFDescriptionUnchecked(t *testing.T) {
+	f := newFakeJira(t)
+	api := &APIConfig{Name: DefaultAPI, URL: f.URL, APIVersion: APIVersion3}
+	r := testAPIReceiver(t, api, &ReceiverConfig{Description: "**{{ .Status }}**", TextFormat: TextFormatADF})
+
+	notify(t, r, testData(nil, testAlert("alertname", "A")))
+	issues := f.Issues()
+	if len(issues) != 1 {
+		t.Fatalf("got %d issues, want 1", len(issues))
+	}
+	if _, ok := issues[0].Fields["description"].(map[string]interface{}); !ok {
+		t.Errorf("got description %v, want an ADF document", issues[0].Fields["description"])
+	}
+	if n := f.Requests("/issue/createmeta"); n != 0 {
+		t.Errorf("got %d create metadata requests, want none without custom fields", n)
+	}
+}
+
+func TestTruncatedNote(t *testing.T) {
+	for _, groupBy := range [][]string{nil, {GroupByGroup}} {
+		f := newFakeJira(t)
+		r := testReceiver(t, f, &ReceiverConfig{GroupBy: groupBy, Description: "{{ .Status }}"})
+		data := testData([]string{"alertname"}, testAlert("alertname", "A"))
+		data.TruncatedAlerts = 3
+
+		notify(t, r, data)
+		issues := f.Issues()
+		if len(issues) != 1 || !strings.Contains(issues[0].Fields["description"].(string), "3 more alerts were truncated") {
+			t.Errorf("groupby %v: got issues %+v, want the truncation noted in the description", groupBy, issues)
+		}
+	}
+}
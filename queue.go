@@ -0,0 +1,395 @@
+package jiralert
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tixu/jiralert/alertmanager"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	queueBucket      = []byte("QUEUE")
+	deadLetterBucket = []byte("DEADLETTER")
+)
+
+// DeliverFunc delivers one queued notification. Returning a PermanentError moves the item to the dead-letter bucket
+// right away, a PartialError schedules a retry of the failed alerts only, any other error a retry of the whole
+// notification.
+type DeliverFunc func(ctx context.Context, data *alertmanager.Data) error
+
+// PermanentError wraps a delivery error that retrying will not fix, e.g. an unknown receiver.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// PartialError wraps a delivery error of some of the alert groups of a notification. Data is the notification
+// restricted to the alerts of these groups, it replaces the queued one.
+type PartialError struct {
+	Err  error
+	Data *alertmanager.Data
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+// QueueOptions configures the delivery workers of a Queue.
+type QueueOptions struct {
+	// Workers is the number of notifications delivered concurrently. The notifications of a receiver are always
+	// delivered one at a time, in the order they were enqueued.
+	Workers int
+	// MaxAttempts is the number of delivery attempts before an item is dead-lettered.
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential backoff applied to a receiver after a failed delivery.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often the queue is scanned for items ready to be delivered.
+	PollInterval time.Duration
+}
+
+// QueueItem is a notification persisted in the queue or in the dead-letter bucket.
+type QueueItem struct {
+	ID        uint64
+	Data      *alertmanager.Data
+	Enqueued  time.Time
+	Attempts  int
+	LastError string
+	NextTry   time.Time
+}
+
+// backoff is the retry state of one receiver.
+type backoff struct {
+	failures int
+	until    time.Time
+}
+
+// Queue is a durable notification queue stored in a bbolt database. Notifications are persisted by Enqueue and
+// delivered asynchronously by a pool of workers, with exponential backoff per receiver. The items of a receiver are
+// delivered sequentially in queue order, so that a resolved notification never overtakes the firing one before it,
+// even while the latter is backing off. Items failing MaxAttempts times are moved to a dead-letter bucket from which
+// they may be listed and replayed.
+type Queue struct {
+	db      *bolt.DB
+	deliver DeliverFunc
+	opts    QueueOptions
+
+	mu       sync.Mutex
+	inflight map[uint64]bool
+	backoffs map[string]*backoff
+
+	wakeup chan struct{}
+}
+
+// NewQueue creates the queue buckets in db if needed and returns a Queue delivering items with deliver.
+func NewQueue(db *bolt.DB, deliver DeliverFunc, opts QueueOptions) (*Queue, error) {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{queueBucket, deadLetterBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("create bucket %s: %s", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{
+		db:       db,
+		deliver:  deliver,
+		opts:     opts,
+		inflight: map[uint64]bool{},
+		backoffs: map[string]*backoff{},
+		wakeup:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Enqueue persists the notification and returns its queue ID.
+func (q *Queue) Enqueue(data *alertmanager.Data) (uint64, error) {
+	if data == nil {
+		return 0, fmt.Errorf("cannot enqueue an empty notification")
+	}
+	item := &QueueItem{Data: data, Enqueued: time.Now()}
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(queueBucket)
+		id, err := bk.NextSequence()
+		if err != nil {
+			return err
+		}
+		item.ID = id
+		return putItem(bk, item)
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Infof("queue: enqueued item %d for receiver %q", item.ID, data.Receiver)
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+	return item.ID, nil
+}
+
+// Run starts the workers and dispatches queued items to them until ctx is canceled.
+func (q *Queue) Run(ctx context.Context) {
+	work := make(chan *QueueItem)
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				q.process(ctx, item)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(q.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		q.dispatch(ctx, work)
+		select {
+		case <-ctx.Done():
+			close(work)
+			wg.Wait()
+			return
+		case <-ticker.C:
+		case <-q.wakeup:
+		}
+	}
+}
+
+// dispatch hands the oldest queued item of every receiver to the workers, if it is ready for delivery. Later items of
+// a receiver wait until the older ones are delivered or dead-lettered.
+func (q *Queue) dispatch(ctx context.Context, work chan<- *QueueItem) {
+	var ready []*QueueItem
+	// blocked holds the receivers whose oldest item has been seen, the items are scanned in ID order.
+	blocked := map[string]bool{}
+	now := time.Now()
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).ForEach(func(k, v []byte) error {
+			item := &QueueItem{}
+			if err := json.Unmarshal(v, item); err != nil {
+				log.Errorf("queue: cannot decode item %d: %s", binary.BigEndian.Uint64(k), err)
+				return nil
+			}
+			if item.Data == nil {
+				log.Errorf("queue: skipping item %d without notification", item.ID)
+				return nil
+			}
+			receiver := item.Data.Receiver
+			if blocked[receiver] {
+				return nil
+			}
+			blocked[receiver] = true
+			if item.NextTry.After(now) {
+				return nil
+			}
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if q.inflight[item.ID] {
+				return nil
+			}
+			if b := q.backoffs[receiver]; b != nil && b.until.After(now) {
+				return nil
+			}
+			q.inflight[item.ID] = true
+			ready = append(ready, item)
+			return nil
+		})
+	})
+	if err != nil {
+		log.Errorf("queue: cannot scan queue: %s", err)
+	}
+	for i, item := range ready {
+		select {
+		case work <- item:
+		case <-ctx.Done():
+			q.mu.Lock()
+			for _, item := range ready[i:] {
+				delete(q.inflight, item.ID)
+			}
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// process delivers one item and records the outcome in the database.
+func (q *Queue) process(ctx context.Context, item *QueueItem) {
+	defer func() {
+		q.mu.Lock()
+		delete(q.inflight, item.ID)
+		q.mu.Unlock()
+	}()
+
+	receiver := item.Data.Receiver
+	err := q.deliver(ctx, item.Data)
+	if err == nil {
+		q.mu.Lock()
+		delete(q.backoffs, receiver)
+		q.mu.Unlock()
+		log.Infof("queue: delivered item %d for receiver %q", item.ID, receiver)
+		if err := q.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(queueBucket).Delete(itob(item.ID)) }); err != nil {
+			log.Errorf("queue: cannot remove delivered item %d: %s", item.ID, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down, the item stays queued and is retried on the next start.
+		return
+	}
+
+	item.Attempts++
+	item.LastError = err.Error()
+	if partial, ok := err.(*PartialError); ok {
+		item.Data = partial.Data
+	}
+	_, permanent := err.(*PermanentError)
+	if permanent || item.Attempts >= q.opts.MaxAttempts {
+		log.Errorf("queue: giving up on item %d for receiver %q after %d attempts: %s", item.ID, receiver, item.Attempts, err)
+		err = q.db.Update(func(tx *bolt.Tx) error {
+			if err := tx.Bucket(queueBucket).Delete(itob(item.ID)); err != nil {
+				return err
+			}
+			return putItem(tx.Bucket(deadLetterBucket), item)
+		})
+		if err != nil {
+			log.Errorf("queue: cannot dead-letter item %d: %s", item.ID, err)
+		}
+		return
+	}
+
+	q.mu.Lock()
+	b := q.backoffs[receiver]
+	if b == nil {
+		b = &backoff{}
+		q.backoffs[receiver] = b
+	}
+	delay := q.opts.MinBackoff << uint(b.failures)
+	if delay > q.opts.MaxBackoff || delay <= 0 {
+		delay = q.opts.MaxBackoff
+	} else {
+		b.failures++
+	}
+	b.until = time.Now().Add(delay)
+	item.NextTry = b.until
+	q.mu.Unlock()
+
+	log.Warnf("queue: delivery of item %d for receiver %q failed (attempt %d), retrying in %s: %s", item.ID, receiver, item.Attempts, delay, err)
+	err = q.db.Update(func(tx *bolt.Tx) error { return putItem(tx.Bucket(queueBucket), item) })
+	if err != nil {
+		log.Errorf("queue: cannot update item %d: %s", item.ID, err)
+	}
+}
+
+// Len returns the number of items waiting for delivery.
+func (q *Queue) Len() int {
+	var n int
+	q.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(queueBucket).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// DeadLetters returns the items in the dead-letter bucket.
+func (q *Queue) DeadLetters() ([]*QueueItem, error) {
+	items := []*QueueItem{}
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).ForEach(func(k, v []byte) error {
+			item := &QueueItem{}
+			if err := json.Unmarshal(v, item); err != nil {
+				return fmt.Errorf("cannot decode dead-letter item %d: %s", binary.BigEndian.Uint64(k), err)
+			}
+			items = append(items, item)
+			return nil
+		})
+	})
+	return items, err
+}
+
+// Replay moves the dead-letter item with the given ID back to the queue, resetting its attempts.
+func (q *Queue) Replay(id uint64) error {
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(deadLetterBucket)
+		v := dead.Get(itob(id))
+		if v == nil {
+			return fmt.Errorf("no dead-letter item with ID %d", id)
+		}
+		item := &QueueItem{}
+		if err := json.Unmarshal(v, item); err != nil {
+			return err
+		}
+		if err := dead.Delete(itob(id)); err != nil {
+			return err
+		}
+		item.Attempts = 0
+		item.NextTry = time.Time{}
+		return putItem(tx.Bucket(queueBucket), item)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("queue: replaying item %d", id)
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// ReplayAll moves every dead-letter item back to the queue and returns their IDs.
+func (q *Queue) ReplayAll() ([]uint64, error) {
+	items, err := q.DeadLetters()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(items))
+	for _, item := range items {
+		if err := q.Replay(item.ID); err != nil {
+			return ids, err
+		}
+		ids = append(ids, item.ID)
+	}
+	return ids, nil
+}
+
+func putItem(bk *bolt.Bucket, item *QueueItem) error {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return bk.Put(itob(item.ID), b)
+}
+
+// itob returns the big endian representation of v, so that keys are iterated in insertion order.
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,266 @@
+package jiralert
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tixu/jiralert/alertmanager"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testQueue opens a queue in a temporary database, delivering items with deliver. The queue runs until the test ends.
+func testQueue(t *testing.T, deliver DeliverFunc, opts QueueOptions) *Queue {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "jiralert.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 10 * time.Millisecond
+	}
+	q, err := NewQueue(db, deliver, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		db.Close()
+	})
+	return q
+}
+
+// waitFor polls cond until it holds or a few seconds have passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// deliveries records the delivered notifications and when they were delivered.
+type deliveries struct {
+	mu    sync.Mutex
+	data  []*alertmanager.Data
+	times []time.Time
+}
+
+func (d *deliveries) add(data *alertmanager.Data) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = append(d.data, data)
+	d.times = append(d.times, time.Now())
+	return len(d.data)
+}
+
+func (d *deliveries) get() []*alertmanager.Data {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]*alertmanager.Data(nil), d.data...)
+}
+
+func (d *deliveries) getTimes() []time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]time.Time(nil), d.times...)
+}
+
+func TestQueueEnqueue(t *testing.T) {
+	var got deliveries
+	q := testQueue(t, func(ctx context.Context, data *alertmanager.Data) error {
+		got.add(data)
+		return nil
+	}, QueueOptions{})
+
+	if _, err := q.Enqueue(nil); err == nil {
+		t.Error("enqueued an empty notification")
+	}
+	for _, status := range []string{alertmanager.AlertFiring, alertmanager.AlertResolved} {
+		data := testData(nil, testAlert("alertname", "A"))
+		data.Status = status
+		if _, err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the deliveries", func() bool { return len(got.get()) == 2 && q.Len() == 0 })
+	if data := got.get(); data[0].Status != alertmanager.AlertFiring || data[1].Status != alertmanager.AlertResolved {
+		t.Errorf("got %s then %s, want the notifications in queue order", data[0].Status, data[1].Status)
+	}
+}
+
+func TestQueueOrderPerReceiver(t *testing.T) {
+	var (
+		got      deliveries
+		mu       sync.Mutex
+		active   = map[string]int{}
+		failures int
+	)
+	q := testQueue(t, func(ctx context.Context, data *alertmanager.Data) error {
+		mu.Lock()
+		active[data.Receiver]++
+		concurrent := active[data.Receiver]
+		// The firing notification of receiver a fails twice and backs off.
+		fail := data.Receiver == "a" && data.Status == alertmanager.AlertFiring && failures < 2
+		if fail {
+			failures++
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active[data.Receiver]--
+			mu.Unlock()
+		}()
+		if concurrent > 1 {
+			t.Errorf("%d concurrent deliveries for receiver %s", concurrent, data.Receiver)
+		}
+		time.Sleep(10 * time.Millisecond)
+		got.add(data)
+		if fail {
+			return errors.New("JIRA unavailable")
+		}
+		return nil
+	}, QueueOptions{Workers: 4, MaxAttempts: 5, MinBackoff: 50 * time.Millisecond})
+
+	for _, n := range []struct{ receiver, status string }{
+		{"a", alertmanager.AlertFiring},
+		{"a", alertmanager.AlertResolved},
+		{"b", alertmanager.AlertFiring},
+		{"b", alertmanager.AlertResolved},
+	} {
+		data := testData(nil, testAlert("alertname", "A"))
+		data.Receiver, data.Status = n.receiver, n.status
+		if _, err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the deliveries", func() bool { return q.Len() == 0 })
+
+	statuses := map[string][]string{}
+	for _, data := range got.get() {
+		statuses[data.Receiver] = append(statuses[data.Receiver], data.Status)
+	}
+	want := map[string][]string{
+		"a": {alertmanager.AlertFiring, alertmanager.AlertFiring, alertmanager.AlertFiring, alertmanager.AlertResolved},
+		"b": {alertmanager.AlertFiring, alertmanager.AlertResolved},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("got deliveries %v, want %v", statuses, want)
+	}
+}
+
+func TestQueueRetryBackoff(t *testing.T) {
+	var got deliveries
+	q := testQueue(t, func(ctx context.Context, data *alertmanager.Data) error {
+		switch got.add(data) {
+		case 1:
+			return errors.New("JIRA unavailable")
+		case 2:
+			retry := *data
+			retry.Alerts = data.Alerts[1:]
+			return &PartialError{Err: errors.New("group B failed"), Data: &retry}
+		}
+		return nil
+	}, QueueOptions{MaxAttempts: 5, MinBackoff: 50 * time.Millisecond, MaxBackoff: 80 * time.Millisecond})
+
+	if _, err := q.Enqueue(testData(nil, testAlert("alertname", "A"), testAlert("alertname", "B"))); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the deliveries", func() bool { return q.Len() == 0 })
+
+	data := got.get()
+	if len(data) != 3 {
+		t.Fatalf("got %d deliveries, want 3", len(data))
+	}
+	if len(data[1].Alerts) != 2 || len(data[2].Alerts) != 1 || data[2].Alerts[0].Labels["alertname"] != "B" {
+		t.Errorf("got alerts %v then %v, want the whole notification retried, then alert B only", data[1].Alerts, data[2].Alerts)
+	}
+	// The backoff doubles from MinBackoff and is capped at MaxBackoff.
+	times := got.getTimes()
+	for i, min := range []time.Duration{50 * time.Millisecond, 80 * time.Millisecond} {
+		if delay := times[i+1].Sub(times[i]); delay < min {
+			t.Errorf("retry %d after %s, want at least %s", i+1, delay, min)
+		}
+	}
+}
+
+func TestQueueDeadLetterReplay(t *testing.T) {
+	var (
+		got       deliveries
+		mu        sync.Mutex
+		permanent = true
+	)
+	q := testQueue(t, func(ctx context.Context, data *alertmanager.Data) error {
+		got.add(data)
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case data.Receiver == "unknown" && permanent:
+			return &PermanentError{Err: errors.New("Receiver missing: unknown")}
+		case data.Receiver == "failing":
+			return errors.New("JIRA unavailable")
+		}
+		return nil
+	}, QueueOptions{MaxAttempts: 2, MinBackoff: 10 * time.Millisecond})
+
+	for _, receiver := range []string{"unknown", "failing"} {
+		data := testData(nil, testAlert("alertname", "A"))
+		data.Receiver = receiver
+		if _, err := q.Enqueue(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the dead letters", func() bool { return q.Len() == 0 })
+
+	items, err := q.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got dead letters %+v, want 2", items)
+	}
+	for i, want := range []struct {
+		receiver string
+		attempts int
+	}{{"unknown", 1}, {"failing", 2}} {
+		if item := items[i]; item.Data.Receiver != want.receiver || item.Attempts != want.attempts || item.LastError == "" {
+			t.Errorf("got dead letter %+v, want receiver %s dead-lettered after %d attempts", item, want.receiver, want.attempts)
+		}
+	}
+
+	if err := q.Replay(42); err == nil {
+		t.Error("replayed an unknown item")
+	}
+	mu.Lock()
+	permanent = false
+	mu.Unlock()
+	delivered := len(got.get())
+	if err := q.Replay(items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the replayed delivery", func() bool { return q.Len() == 0 && len(got.get()) == delivered+1 })
+	if items, err := q.DeadLetters(); err != nil || len(items) != 1 || items[0].Data.Receiver != "failing" {
+		t.Errorf("got dead letters %+v (%v), want the failing receiver's only", items, err)
+	}
+
+	ids, err := q.ReplayAll()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("replayed %v (%v), want one item", ids, err)
+	}
+	waitFor(t, "the replayed item to be dead-lettered again", func() bool {
+		items, err := q.DeadLetters()
+		return err == nil && len(items) == 1 && q.Len() == 0
+	})
+}
@@ -0,0 +1,93 @@
+package jiralert
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tixu/jiralert/alertmanager"
+)
+
+// RenderedField is a receiver template rendered for an alert group.
+type RenderedField struct {
+	Name  string
+	Value string
+	// Err is the template error, it gives the template and line at fault.
+	Err error
+}
+
+// RenderedGroup lists the fields rendered for an alert group, identified by its issue label.
+type RenderedGroup struct {
+	Label  string
+	Fields []RenderedField
+}
+
+// Render renders the templates of the receiver for every alert group of the notification, as Notify would when
+// creating or commenting the group's issue, without contacting JIRA. Each field carries its own error, so that all the
+// failing templates are reported at once. api is the configuration of the receiver's JIRA endpoint, if defined.
+func Render(conf *ReceiverConfig, api *APIConfig, tmpl *Template, data *alertmanager.Data) []RenderedGroup {
+	r := &Receiver{conf: conf, tmpl: tmpl}
+	if api != nil {
+		r.endpoint = &Endpoint{conf: api}
+	}
+	project, projectErr := tmpl.Render("project", conf.Project, data)
+	issueType, issueTypeErr := tmpl.Render("issuetype", conf.IssueType, data)
+
+	var groups []RenderedGroup
+	for _, group := range r.groupAlerts(data) {
+		rendered := RenderedGroup{Label: group.label}
+		add := func(name, value string, err error) {
+			rendered.Fields = append(rendered.Fields, RenderedField{Name: name, Value: value, Err: err})
+		}
+		render := func(name, text string) {
+			value, err := tmpl.Render(name, text, group.data)
+			add(name, value, err)
+		}
+		// The description and comment are shown as sent, in the receiver's TextFormat.
+		renderText := func(name, text string) {
+			value, err := tmpl.Render(name, text, group.data)
+			formatted := r.formatText(value + group.note)
+			if s, ok := formatted.(string); ok {
+				add(name, s, err)
+				return
+			}
+			b, jsonErr := json.MarshalIndent(formatted, "", "  ")
+			if err == nil {
+				err = jsonErr
+			}
+			add(name, string(b), err)
+		}
+
+		add("project", project, projectErr)
+		add("issuetype", issueType, issueTypeErr)
+		render("summary", conf.Summary)
+		if conf.Priority != "" {
+			render("priority", conf.Priority)
+		}
+		labels := []string{group.label}
+		if conf.AddGroupLabels {
+			for k, v := range data.GroupLabels {
+				labels = append(labels, fmt.Sprintf("%s=%q", k, v))
+			}
+		}
+		add("labels", fmt.Sprint(labels), nil)
+		renderText("description", conf.Description)
+		renderText("comment", conf.Comment)
+
+		ids := make([]string, 0, len(conf.Fields))
+		for id := range conf.Fields {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			rd := &renderer{tmpl: tmpl, data: group.data}
+			value, err := json.Marshal(mapStrings(conf.Fields[id], "fields."+id, rd.render))
+			if rd.err != nil {
+				err = rd.err
+			}
+			add("fields."+id, string(value), err)
+		}
+		groups = append(groups, rendered)
+	}
+	return groups
+}
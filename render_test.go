@@ -0,0 +1,37 @@
+package jiralert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderFieldErrors(t *testing.T) {
+	tmpl := testTemplate(t, `{{ define "jira.summary" }}Alerts {{ .Status }}{{ end }}`)
+	conf := &ReceiverConfig{
+		Name:      "test",
+		Project:   fakeProject,
+		IssueType: fakeIssueType,
+		Summary:   `{{ template "jira.summary" . }}`,
+		GroupBy:   []string{GroupByAlert},
+		Fields: map[string]interface{}{
+			"customfield_10001": "{{ .Labels.instance }}",
+			"customfield_10002": "{{ .NoSuchField }}",
+		},
+	}
+	groups := Render(conf, nil, tmpl, testData(nil, testAlert("alertname", "A", "instance", "db1")))
+	if len(groups) != 1 {
+		t.Fatalf("got %d groups, want 1", len(groups))
+	}
+	fields := map[string]RenderedField{}
+	for _, field := range groups[0].Fields {
+		fields[field.Name] = field
+	}
+	for name, want := range map[string]string{"summary": "Alerts firing", "fields.customfield_10001": `"db1"`} {
+		if field := fields[name]; field.Err != nil || field.Value != want {
+			t.Errorf("got %s %q (%v), want %q", name, field.Value, field.Err, want)
+		}
+	}
+	if err := fields["fields.customfield_10002"].Err; err == nil || !strings.Contains(err.Error(), "NoSuchField") {
+		t.Errorf("got error %v, want the broken field reported", err)
+	}
+}
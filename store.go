@@ -0,0 +1,323 @@
+package jiralert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrIssueNotFound is returned by IssueStore.Get when no issue is stored for a label.
+var ErrIssueNotFound = errors.New("issue not found locally")
+
+// IssueStore caches the mapping from issue labels to JIRA issue IDs, so that issues need not be searched for on
+// every notification. Implementations must be safe for concurrent use. Get, Put and Delete return ctx.Err() without
+// touching the store if ctx is already done.
+type IssueStore interface {
+	// Get returns the issue ID stored for the label, or ErrIssueNotFound.
+	Get(ctx context.Context, label string) (string, error)
+	// Put stores the issue ID for the label, replacing any previous one.
+	Put(ctx context.Context, label, id string) error
+	// Delete removes the label, it is not an error if it is not stored.
+	Delete(ctx context.Context, label string) error
+	// List returns all the stored label to issue ID mappings.
+	List() (map[string]string, error)
+	// Iterate calls fn for every stored mapping, in label order, stopping at the first error.
+	Iterate(fn func(label, id string) error) error
+}
+
+var issueBucket = []byte("JIRA")
+
+// BoltStore is an IssueStore backed by a bbolt database. The database is opened by the caller and may be shared with
+// other users, e.g. the delivery queue.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore creates the issue bucket in db if needed and returns a BoltStore using it.
+func NewBoltStore(db *bolt.DB) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(issueBucket); err != nil {
+			return fmt.Errorf("create bucket %s: %s", issueBucket, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Get implements IssueStore.
+func (s *BoltStore) Get(ctx context.Context, label string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	var id string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bs := tx.Bucket(issueBucket).Get([]byte(label))
+		if bs == nil {
+			return ErrIssueNotFound
+		}
+		id = string(bs)
+		return nil
+	})
+	return id, err
+}
+
+// Put implements IssueStore.
+func (s *BoltStore) Put(ctx context.Context, label, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issueBucket).Put([]byte(label), []byte(id))
+	})
+}
+
+// Delete implements IssueStore.
+func (s *BoltStore) Delete(ctx context.Context, label string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issueBucket).Delete([]byte(label))
+	})
+}
+
+// List implements IssueStore.
+func (s *BoltStore) List() (map[string]string, error) {
+	return listStore(s)
+}
+
+// Iterate implements IssueStore.
+func (s *BoltStore) Iterate(fn func(label, id string) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(issueBucket).ForEach(func(k, v []byte) error {
+			return fn(string(k), string(v))
+		})
+	})
+}
+
+// MemoryStore is an IssueStore kept in memory, mostly useful for tests.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	issues map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{issues: map[string]string{}}
+}
+
+// Get implements IssueStore.
+func (s *MemoryStore) Get(ctx context.Context, label string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.issues[label]
+	if !ok {
+		return "", ErrIssueNotFound
+	}
+	return id, nil
+}
+
+// Put implements IssueStore.
+func (s *MemoryStore) Put(ctx context.Context, label, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[label] = id
+	return nil
+}
+
+// Delete implements IssueStore.
+func (s *MemoryStore) Delete(ctx context.Context, label string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.issues, label)
+	return nil
+}
+
+// List implements IssueStore.
+func (s *MemoryStore) List() (map[string]string, error) {
+	return listStore(s)
+}
+
+// Iterate implements IssueStore. fn must not modify the store.
+func (s *MemoryStore) Iterate(fn func(label, id string) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	labels := make([]string, 0, len(s.issues))
+	for label := range s.issues {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		if err := fn(label, s.issues[label]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileStore is an IssueStore kept in memory and saved as a JSON object to a file after every change.
+type FileStore struct {
+	MemoryStore
+	path string
+}
+
+// NewFileStore loads the mappings saved in path, if it exists, and returns a FileStore saving to it.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{MemoryStore: MemoryStore{issues: map[string]string{}}, path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.issues); err != nil {
+		return nil, fmt.Errorf("cannot parse issue store %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Put implements IssueStore.
+func (s *FileStore) Put(ctx context.Context, label, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues[label] = id
+	return s.save()
+}
+
+// Delete implements IssueStore.
+func (s *FileStore) Delete(ctx context.Context, label string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.issues, label)
+	return s.save()
+}
+
+// List implements IssueStore.
+func (s *FileStore) List() (map[string]string, error) {
+	return listStore(s)
+}
+
+// save writes the mappings to a temporary file and renames it over s.path, so the file is never left half written.
+// It must be called with s.mu held.
+func (s *FileStore) save() error {
+	b, err := json.MarshalIndent(s.issues, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func listStore(s IssueStore) (map[string]string, error) {
+	issues := map[string]string{}
+	err := s.Iterate(func(label, id string) error {
+		issues[label] = id
+		return nil
+	})
+	return issues, err
+}
+
+// StateStore keeps small state records of the receivers across notifications, e.g. the issue creation window of the
+// storm protection. Implementations must be safe for concurrent use.
+type StateStore interface {
+	// Get returns the value stored for the key, nil if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Update calls fn with the value stored for the key, nil if there is none, and stores the value fn returns. The
+	// whole update is atomic. Nothing is stored if fn returns an error.
+	Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error
+}
+
+var stateBucket = []byte("STATE")
+
+// BoltStateStore is a StateStore backed by a bbolt database, usually the one of the BoltStore and delivery queue.
+type BoltStateStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStateStore creates the state bucket in db if needed and returns a BoltStateStore using it.
+func NewBoltStateStore(db *bolt.DB) (*BoltStateStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(stateBucket); err != nil {
+			return fmt.Errorf("create bucket %s: %s", stateBucket, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStateStore{db: db}, nil
+}
+
+// Get implements StateStore.
+func (s *BoltStateStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(stateBucket).Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// Update implements StateStore.
+func (s *BoltStateStore) Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(stateBucket)
+		var value []byte
+		if v := b.Get([]byte(key)); v != nil {
+			// bbolt values are only valid during the transaction.
+			value = append([]byte(nil), v...)
+		}
+		value, err := fn(value)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
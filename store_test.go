@@ -0,0 +1,129 @@
+package jiralert
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestIssueStores(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// open opens the store persisted in dir, closeStore releases it.
+		open func(t *testing.T, dir string) (store IssueStore, closeStore func())
+	}{
+		{
+			name: "bolt",
+			open: func(t *testing.T, dir string) (IssueStore, func()) {
+				db, err := bolt.Open(filepath.Join(dir, "jiralert.db"), 0600, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				s, err := NewBoltStore(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s, func() { db.Close() }
+			},
+		},
+		{
+			name: "file",
+			open: func(t *testing.T, dir string) (IssueStore, func()) {
+				s, err := NewFileStore(filepath.Join(dir, "jiralert-issues.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return s, func() {}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, closeStore := tc.open(t, dir)
+
+			if _, err := s.Get(ctx, "a"); err != ErrIssueNotFound {
+				t.Errorf("got %v, want ErrIssueNotFound", err)
+			}
+			for _, m := range [][2]string{{"c", "10003"}, {"a", "10000"}, {"b", "10002"}, {"a", "10001"}} {
+				if err := s.Put(ctx, m[0], m[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if id, err := s.Get(ctx, "a"); err != nil || id != "10001" {
+				t.Errorf("got %q (%v), want the replaced issue 10001", id, err)
+			}
+			if err := s.Delete(ctx, "b"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Delete(ctx, "missing"); err != nil {
+				t.Errorf("deleting a missing label: %v", err)
+			}
+			want := map[string]string{"a": "10001", "c": "10003"}
+			if issues, err := s.List(); err != nil || !reflect.DeepEqual(issues, want) {
+				t.Errorf("got %v (%v), want %v", issues, err, want)
+			}
+
+			var labels []string
+			stop := errors.New("stop")
+			err := s.Iterate(func(label, id string) error {
+				labels = append(labels, label)
+				return stop
+			})
+			if err != stop || !reflect.DeepEqual(labels, []string{"a"}) {
+				t.Errorf("iterated %v (%v), want label a then the error", labels, err)
+			}
+
+			canceled, cancel := context.WithCancel(ctx)
+			cancel()
+			if _, err := s.Get(canceled, "a"); err != context.Canceled {
+				t.Errorf("Get: got %v, want the context error", err)
+			}
+			if err := s.Put(canceled, "d", "10004"); err != context.Canceled {
+				t.Errorf("Put: got %v, want the context error", err)
+			}
+			if err := s.Delete(canceled, "a"); err != context.Canceled {
+				t.Errorf("Delete: got %v, want the context error", err)
+			}
+
+			closeStore()
+			s, closeStore = tc.open(t, dir)
+			defer closeStore()
+			if issues, err := s.List(); err != nil || !reflect.DeepEqual(issues, want) {
+				t.Errorf("after reopening: got %v (%v), want %v", issues, err, want)
+			}
+		})
+	}
+}
+
+func TestFileStoreSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jiralert-issues.json")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(context.Background(), "a", "10000"); err != nil {
+		t.Fatal(err)
+	}
+	// The temporary file was renamed over the store file.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "jiralert-issues.json" {
+		t.Errorf("got files %v, want the store file only", files)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path); err == nil {
+		t.Error("opened a corrupted store")
+	}
+}
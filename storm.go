@@ -0,0 +1,135 @@
+package jiralert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tixu/jiralert/alertmanager"
+)
+
+// DefaultStormWindow is the storm protection window of receivers setting MaxIssuesPerWindow but no StormWindow.
+const DefaultStormWindow = time.Hour
+
+// stormWindow is the issue creation window of a receiver, persisted in the StateStore.
+type stormWindow struct {
+	Start   time.Time
+	Created int
+}
+
+// reserveIssue counts an issue creation in the current window of the receiver and returns whether it is within the
+// MaxIssuesPerWindow cap, along with the window start. A new window starts when the previous one is over.
+func (r *Receiver) reserveIssue(ctx context.Context) (bool, time.Time, error) {
+	period := r.conf.StormWindow
+	if period <= 0 {
+		period = DefaultStormWindow
+	}
+	var (
+		allowed bool
+		window  stormWindow
+	)
+	err := r.state.Update(ctx, "storm/"+r.conf.Name, func(value []byte) ([]byte, error) {
+		window = stormWindow{}
+		if value != nil {
+			if err := json.Unmarshal(value, &window); err != nil {
+				log.Warnf("ignoring invalid storm protection state of receiver %s: %s", r.conf.Name, err)
+				window = stormWindow{}
+			}
+		}
+		now := time.Now()
+		if now.Sub(window.Start) >= period {
+			window = stormWindow{Start: now}
+		}
+		allowed = window.Created < r.conf.MaxIssuesPerWindow
+		if allowed {
+			window.Created++
+		}
+		return json.Marshal(window)
+	})
+	return allowed, window.Start, err
+}
+
+// errWindowOver aborts the state update of releaseIssue.
+var errWindowOver = errors.New("storm window over")
+
+// releaseIssue gives back the issue creation counted by reserveIssue in the window starting at start, when the issue
+// could not be created. It is not bound to the notification context, which is usually done when the creation failed
+// on a timeout.
+func (r *Receiver) releaseIssue(start time.Time) {
+	err := r.state.Update(context.Background(), "storm/"+r.conf.Name, func(value []byte) ([]byte, error) {
+		var window stormWindow
+		if value == nil || json.Unmarshal(value, &window) != nil || !window.Start.Equal(start) || window.Created == 0 {
+			return nil, errWindowOver
+		}
+		window.Created--
+		return json.Marshal(window)
+	})
+	if err != nil && err != errWindowOver {
+		log.Warnf("got an error while updating the state store %s", err)
+	}
+}
+
+// foldIntoStorm lists the alerts of the group in a comment on the storm issue of the current window, creating the
+// storm issue first if needed. The templates of the storm issue are rendered with the data of the group opening it,
+// as those of the group's own issue would have been, so that alert-scoped templates work when grouping by alert.
+func (r *Receiver) foldIntoStorm(ctx context.Context, project string, data *alertmanager.Data, group *alertGroup, start time.Time) StatusNotify {
+	period := r.conf.StormWindow
+	if period <= 0 {
+		period = DefaultStormWindow
+	}
+	stormLabel := toIssueLabel(alertmanager.KV{"receiver": r.conf.Name, "storm": start.UTC().Format("20060102T150405Z")})
+	lockKey := r.endpoint.conf.URL + "\x00" + project + "\x00" + stormLabel
+	if err := issueLocks.Lock(ctx, lockKey); err != nil {
+		return StatusNotify{Status: http.StatusGatewayTimeout, Err: err}
+	}
+	defer issueLocks.Unlock(lockKey)
+
+	issue, err := r.getIssue(ctx, stormLabel, project)
+	if err != nil {
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+	if issue == nil {
+		log.Warnf("Receiver %s created %d issues since %s, opening a storm issue", r.conf.Name, r.conf.MaxIssuesPerWindow, start)
+		rd := &renderer{tmpl: r.tmpl, data: group.data}
+		issue = r.newIssue(project, data, stormLabel, rd)
+		issue.Fields.Summary = fmt.Sprintf("Alert storm: receiver %s reached %d issues since %s", r.conf.Name,
+			r.conf.MaxIssuesPerWindow, start.UTC().Format(time.RFC3339))
+		r.setDescription(issue, fmt.Sprintf("JIRAlert created %d issues for receiver %s since %s. Until %s, the alerts "+
+			"of new issues are listed in comments on this issue instead.", r.conf.MaxIssuesPerWindow, r.conf.Name,
+			start.UTC().Format(time.RFC3339), start.Add(period).UTC().Format(time.RFC3339)))
+		if rd.err != nil {
+			return StatusNotify{Status: http.StatusInternalServerError, Err: fmt.Errorf("issue %s: %s", stormLabel, rd.err)}
+		}
+		if issue, err = r.create(ctx, project, issue); err != nil {
+			return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+		}
+		if err := r.store.Put(ctx, stormLabel, issue.ID); err != nil {
+			log.Warnf("got an error while updating the issue store %s", err)
+		}
+	}
+
+	log.Infof("Issue creation for %s suppressed by the storm protection, listing the alerts on %s", group.label, issue.Key)
+	if err := r.addComment(ctx, issue, stormComment(group)); err != nil {
+		return StatusNotify{Status: http.StatusInternalServerError, Err: err}
+	}
+	return StatusNotify{Status: http.StatusOK, Err: nil}
+}
+
+// stormComment lists the alerts of a group folded into the storm issue.
+func stormComment(group *alertGroup) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Issue %s not created, the alert storm limit is reached. Alerts:\n", group.label)
+	for _, alert := range group.alerts {
+		fmt.Fprintf(&buf, "* %s since %s", toIssueLabel(alert.Labels), alert.StartsAt.UTC().Format(time.RFC3339))
+		if alert.GeneratorURL != "" {
+			fmt.Fprintf(&buf, " (%s)", alert.GeneratorURL)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
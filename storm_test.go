@@ -0,0 +1,90 @@
+package jiralert
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStormIssueFields(t *testing.T) {
+	f := newFakeJira(t)
+	f.fields["customfield_10001"] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	// Grouping by alert, the templates are alert-scoped.
+	r := testReceiver(t, f, &ReceiverConfig{
+		Priority:           `{{ if eq .Labels.severity "critical" }}Critical{{ else }}Major{{ end }}`,
+		Fields:             map[string]interface{}{"customfield_10001": "{{ .Labels.instance }}"},
+		AddGroupLabels:     true,
+		MaxIssuesPerWindow: 1,
+	})
+
+	notify(t, r, testData([]string{"alertname"},
+		testAlert("alertname", "A", "instance", "1"),
+		testAlert("alertname", "A", "instance", "2", "severity", "critical")))
+	issues := f.Issues()
+	if len(issues) != 2 {
+		t.Fatalf("got %d issues, want the issue of the first alert and the storm issue", len(issues))
+	}
+	storm := issues[1]
+	if !strings.HasPrefix(storm.Fields["summary"].(string), "Alert storm") {
+		t.Fatalf("second issue is %q, want the storm issue", storm.Fields["summary"])
+	}
+	if got := storm.Fields["customfield_10001"]; got != "2" {
+		t.Errorf("storm issue customfield_10001 = %v, want the field rendered with the second alert", got)
+	}
+	if got := storm.Fields["priority"].(map[string]interface{})["name"]; got != "Critical" {
+		t.Errorf("storm issue priority = %v, want the priority rendered with the second alert", got)
+	}
+	if labels := storm.Fields["labels"].([]interface{}); len(labels) != 2 || labels[1] != `alertname="A"` {
+		t.Errorf("storm issue labels = %v, want the storm label and the group labels", labels)
+	}
+	if len(storm.Comments) != 1 || !strings.Contains(storm.Comments[0].(string), `instance="2"`) {
+		t.Errorf("storm issue comments = %v, want the second alert listed", storm.Comments)
+	}
+	if n := f.Requests("/issue/createmeta"); n == 0 {
+		t.Error("the fields of the storm issue were not checked against the create metadata")
+	}
+}
+
+func TestStormReleaseFailedCreation(t *testing.T) {
+	f := newFakeJira(t)
+	f.failCreates = 1
+	r := testReceiver(t, f, &ReceiverConfig{MaxIssuesPerWindow: 1})
+
+	data := testData(nil, testAlert("alertname", "A"))
+	statuses, err := r.Notify(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for label, status := range statuses {
+		if status.Status != http.StatusInternalServerError {
+			t.Fatalf("issue %s: status %d, want the creation to fail", label, status.Status)
+		}
+	}
+	notify(t, r, data)
+	issues := f.Issues()
+	if len(issues) != 1 || strings.HasPrefix(issues[0].Fields["summary"].(string), "Alert storm") {
+		t.Fatalf("got issues %+v, want the issue of the alert: the failed creation must not count", issues)
+	}
+
+	// The window is full now.
+	notify(t, r, testData(nil, testAlert("alertname", "B")))
+	if issues := f.Issues(); len(issues) != 2 || !strings.HasPrefix(issues[1].Fields["summary"].(string), "Alert storm") {
+		t.Fatalf("got %d issues, want the storm issue last", len(issues))
+	}
+}
+
+func TestReleaseIssueOtherWindow(t *testing.T) {
+	r := &Receiver{conf: &ReceiverConfig{Name: "test", MaxIssuesPerWindow: 2}, state: newMemStateStore()}
+	ctx := context.Background()
+	_, start, err := r.reserveIssue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.releaseIssue(start.Add(-time.Hour))
+	r.reserveIssue(ctx)
+	if allowed, _, _ := r.reserveIssue(ctx); allowed {
+		t.Error("releasing a creation of a previous window freed the current one")
+	}
+}
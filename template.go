@@ -2,33 +2,24 @@ package jiralert
 
 import (
 	"bytes"
-	"regexp"
+	"fmt"
+	"reflect"
 	"strings"
+	"sync"
 	"text/template"
 
 	log "github.com/sirupsen/logrus"
+	"go.uber.org/multierr"
 )
 
-// Template wraps a text template and error, to make it easier to execute multiple templates and only check for errors
-// once at the end (assuming one is only interested in the first error, which is usually the case).
+// Template wraps the text templates defined in the template file, which the receiver templates may reference. The
+// receiver templates are parsed once, by Compile or on their first use, and cached. A Template is safe for concurrent
+// use: it is loaded with the configuration and shared by all receivers and notifications.
 type Template struct {
 	tmpl *template.Template
-	err  error
-}
 
-var funcs = template.FuncMap{
-	"toUpper": strings.ToUpper,
-	"toLower": strings.ToLower,
-	"title":   strings.Title,
-	// join is equal to strings.Join but inverts the argument order
-	// for easier pipelining in templates.
-	"join": func(sep string, s []string) string {
-		return strings.Join(s, sep)
-	},
-	"reReplaceAll": func(pattern, repl, text string) string {
-		re := regexp.MustCompile(pattern)
-		return re.ReplaceAllString(text, repl)
-	},
+	mu       sync.RWMutex
+	compiled map[string]*template.Template // keyed by name and text
 }
 
 // LoadTemplate reads and parses all templates defined in the given file and constructs a jiralert.Template.
@@ -38,35 +29,142 @@ func LoadTemplate(path string) (*Template, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Template{tmpl: tmpl}, nil
+	return &Template{tmpl: tmpl, compiled: map[string]*template.Template{}}, nil
 }
 
-// Execute parses the provided text (or returns it unchanged if not a Go template), associates it with the templates
-// defined in t.tmpl (so they may be referenced and used) and applies the resulting template to the specified data
-// object, returning the output as a string.
-func (t *Template) Execute(text string, data interface{}) string {
-
+// Render applies the provided text (or returns it unchanged if not a Go template), compiled as a template called
+// name, to the specified data object, returning the output as a string. Errors are prefixed with name and give the
+// template and line at fault, e.g. `summary: template: jiralert.tmpl:3:5: executing "jira.summary" at <.Labels.foo>:
+// ...`.
+func (t *Template) Render(name, text string, data interface{}) (string, error) {
 	if !strings.Contains(text, "{{") {
-		log.Infof("  returning unchanged.")
-		return text
+		return text, nil
 	}
 
-	if t.err != nil {
-		return ""
+	tmpl, err := t.compile(name, text)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
 	}
-	var tmpl *template.Template
-	tmpl, t.err = t.tmpl.Clone()
-	if t.err != nil {
-		return ""
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%s: %s", name, err)
 	}
-	tmpl, t.err = tmpl.New("").Parse(text)
-	if t.err != nil {
-		log.Infof("  parse failed.")
-		return ""
+	return buf.String(), nil
+}
+
+// compile returns the provided text parsed as a template called name, associated with the templates defined in t.tmpl
+// (so they may be referenced and used). Parsed templates are cached, they are only parsed on the first call.
+func (t *Template) compile(name, text string) (*template.Template, error) {
+	key := name + "\x00" + text
+	t.mu.RLock()
+	tmpl, ok := t.compiled[key]
+	t.mu.RUnlock()
+	if ok {
+		return tmpl, nil
 	}
-	var buf bytes.Buffer
-	t.err = tmpl.Execute(&buf, data)
-	ret := buf.String()
 
-	return ret
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl, err = tmpl.New(name).Parse(text); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if cached, ok := t.compiled[key]; ok {
+		return cached, nil
+	}
+	t.compiled[key] = tmpl
+	return tmpl, nil
+}
+
+// Compile parses all the templates of the receivers, under the names Render is called with, so that notifications
+// never parse templates. It returns the parse errors of all the templates, prefixed with the YAML path of the field,
+// e.g. `receivers[0].fields.customfield_10001: template: ...`.
+func (t *Template) Compile(receivers []*ReceiverConfig) error {
+	var errs error
+	for i, rc := range receivers {
+		path := fmt.Sprintf("receivers[%d]", i)
+		for _, field := range []struct{ name, text string }{
+			{"project", rc.Project},
+			{"issuetype", rc.IssueType},
+			{"summary", rc.Summary},
+			{"priority", rc.Priority},
+			{"description", rc.Description},
+			{"comment", rc.Comment},
+		} {
+			errs = multierr.Append(errs, t.compileText(path, field.name, field.text))
+		}
+		mapStrings(rc.Fields, "fields", func(name, text string) string {
+			errs = multierr.Append(errs, t.compileText(path, name, text))
+			return text
+		})
+	}
+	return errs
+}
+
+func (t *Template) compileText(path, name, text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+	if _, err := t.compile(name, text); err != nil {
+		return fmt.Errorf("%s.%s: %s", path, name, err)
+	}
+	return nil
+}
+
+// mapStrings returns a deep copy of a map/slice/array/string/int/bool or combination thereof, with all string keys and
+// values replaced by fn. All maps are converted to map[string]interface{}, with all non-string keys discarded. name is
+// the path of value, e.g. "fields.customfield_10001", fn is called with the path of each string.
+func mapStrings(value interface{}, name string, fn func(name, s string) string) interface{} {
+	if value == nil {
+		return value
+	}
+
+	valueMeta := reflect.ValueOf(value)
+	switch valueMeta.Kind() {
+
+	case reflect.String:
+		return fn(name, valueMeta.String())
+
+	case reflect.Array, reflect.Slice:
+		arrayLen := valueMeta.Len()
+		converted := make([]interface{}, arrayLen)
+		for i := 0; i < arrayLen; i++ {
+			converted[i] = mapStrings(valueMeta.Index(i).Interface(), fmt.Sprintf("%s[%d]", name, i), fn)
+		}
+		return converted
+
+	case reflect.Map:
+		keys := valueMeta.MapKeys()
+		converted := make(map[string]interface{}, len(keys))
+
+		for _, keyMeta := range keys {
+			strKey, isString := keyMeta.Interface().(string)
+			if !isString {
+				continue
+			}
+			path := name + "." + strKey
+			converted[fn(path, strKey)] = mapStrings(valueMeta.MapIndex(keyMeta).Interface(), path, fn)
+		}
+		return converted
+
+	default:
+		return value
+	}
+}
+
+// renderer renders several templates against the same data, collecting the errors of all of them.
+type renderer struct {
+	tmpl *Template
+	data interface{}
+	err  error
+}
+
+// render renders text as the template called name, recording the error if any.
+func (rd *renderer) render(name, text string) string {
+	out, err := rd.tmpl.Render(name, text, rd.data)
+	rd.err = multierr.Append(rd.err, err)
+	return out
 }
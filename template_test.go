@@ -0,0 +1,52 @@
+package jiralert
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.uber.org/multierr"
+)
+
+func TestCompileErrorPaths(t *testing.T) {
+	tmpl := testTemplate(t, `{{ define "jira.summary" }}{{ .Status }}{{ end }}`)
+	err := tmpl.Compile([]*ReceiverConfig{
+		{Name: "ok", Summary: `{{ template "jira.summary" . }}`},
+		{
+			Name:    "broken",
+			Summary: "{{ .Status",
+			Fields: map[string]interface{}{
+				"customfield_10001": []interface{}{"ok", map[string]interface{}{"value": "{{ end }}"}},
+			},
+		},
+	})
+	errs := multierr.Errors(err)
+	if len(errs) != 2 {
+		t.Fatalf("got errors %v, want the summary and the field", errs)
+	}
+	for i, prefix := range []string{"receivers[1].summary: ", "receivers[1].fields.customfield_10001[1].value: "} {
+		if !strings.HasPrefix(errs[i].Error(), prefix) {
+			t.Errorf("error %q, want it prefixed with %q", errs[i], prefix)
+		}
+	}
+}
+
+func TestMapStrings(t *testing.T) {
+	value := map[string]interface{}{
+		"a": []interface{}{"x", 1, map[interface{}]interface{}{"b": "y", 2: "dropped"}},
+	}
+	var paths []string
+	got := mapStrings(value, "fields", func(name, s string) string {
+		paths = append(paths, name)
+		return strings.ToUpper(s)
+	})
+	want := map[string]interface{}{
+		"A": []interface{}{"X", 1, map[string]interface{}{"B": "Y"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if want := "fields.a fields.a[0] fields.a[2].b fields.a[2].b"; strings.Join(paths, " ") != want {
+		t.Errorf("got paths %v, want %s", paths, want)
+	}
+}
@@ -0,0 +1,113 @@
+package jiralert
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+// JIRA Cloud has no user names, the REST API v3 identifies users by account ID. The user fields of the receivers may
+// still name users, by email address or display name, or set their account ID directly.
+
+// cloudUser is a JIRA Cloud user, as returned by the user search.
+type cloudUser struct {
+	AccountID    string `json:"accountId"`
+	EmailAddress string `json:"emailAddress"`
+	DisplayName  string `json:"displayName"`
+}
+
+// userFields returns the IDs of the user fields of the issue type, mapped to whether they hold a list of users.
+func userFields(meta *jira.MetaIssueType) map[string]bool {
+	fields := map[string]bool{}
+	for id, field := range meta.Fields {
+		field, _ := field.(map[string]interface{})
+		schema, _ := field["schema"].(map[string]interface{})
+		switch {
+		case schema["type"] == "user":
+			fields[id] = false
+		case schema["type"] == "array" && schema["items"] == "user":
+			fields[id] = true
+		}
+	}
+	return fields
+}
+
+// resolveUsers replaces the users named in the user fields by their account ID.
+func (r *Receiver) resolveUsers(ctx context.Context, meta *jira.MetaIssueType, fields map[string]interface{}) error {
+	for id, list := range userFields(meta) {
+		value, ok := fields[id]
+		if !ok {
+			continue
+		}
+		if !list {
+			user, err := r.resolveUser(ctx, value)
+			if err != nil {
+				return fmt.Errorf("field %s: %s", id, err)
+			}
+			fields[id] = user
+			continue
+		}
+		users, _ := value.([]interface{})
+		for i := range users {
+			user, err := r.resolveUser(ctx, users[i])
+			if err != nil {
+				return fmt.Errorf("field %s: %s", id, err)
+			}
+			users[i] = user
+		}
+	}
+	return nil
+}
+
+// resolveUser returns the value of a user field, either a user name or a {"name": ...} object, as an
+// {"accountId": ...} object. Values already holding an account ID are returned unchanged.
+func (r *Receiver) resolveUser(ctx context.Context, value interface{}) (interface{}, error) {
+	var name string
+	switch v := value.(type) {
+	case string:
+		name = v
+	case map[string]interface{}:
+		if _, ok := v["accountId"]; ok {
+			return value, nil
+		}
+		name, _ = v["name"].(string)
+	default:
+		return value, nil
+	}
+	if name == "" {
+		return value, nil
+	}
+	accountID, err := r.endpoint.accountID(ctx, r, name)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"accountId": accountID}, nil
+}
+
+// accountID returns the account ID of the only user whose email address, display name or account ID is the given
+// name. The user search also matches name prefixes, its other results are ignored. Account IDs are cached for the life of the endpoint, i.e. until the configuration is reloaded.
+func (e *Endpoint) accountID(ctx context.Context, r *Receiver, name string) (string, error) {
+	if id, ok := e.accounts.Load(name); ok {
+		return id.(string), nil
+	}
+	users, resp, err := r.searchUsers(ctx, name)
+	if err != nil {
+		return "", handleJiraError("User.Find", resp, err)
+	}
+	var matches []cloudUser
+	for _, user := range users {
+		if strings.EqualFold(user.EmailAddress, name) || strings.EqualFold(user.DisplayName, name) || user.AccountID == name {
+			matches = append(matches, user)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no JIRA user matches %q", name)
+	case 1:
+		e.accounts.Store(name, matches[0].AccountID)
+		return matches[0].AccountID, nil
+	}
+	return "", fmt.Errorf("%d JIRA users match %q, use their account ID", len(matches), name)
+}
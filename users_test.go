@@ -0,0 +1,65 @@
+package jiralert
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveUsers(t *testing.T) {
+	f := newFakeJira(t)
+	f.fields["customfield_10010"] = map[string]interface{}{"schema": map[string]interface{}{"type": "user"}}
+	f.fields["customfield_10011"] = map[string]interface{}{"schema": map[string]interface{}{"type": "array", "items": "user"}}
+	// The user search matches name prefixes, as JIRA Cloud does.
+	users := []cloudUser{
+		{AccountID: "acc-jane", EmailAddress: "jane@example.com", DisplayName: "Jane Doe"},
+		{AccountID: "acc-janet", EmailAddress: "janet@example.com", DisplayName: "Janet Roe"},
+		{AccountID: "acc-john", EmailAddress: "john@example.com", DisplayName: "John Doe"},
+	}
+	f.handler = func(w http.ResponseWriter, req *http.Request) bool {
+		if !strings.HasSuffix(req.URL.Path, "/user/search") {
+			return false
+		}
+		query := strings.ToLower(req.URL.Query().Get("query"))
+		matches := []cloudUser{}
+		for _, user := range users {
+			if strings.HasPrefix(strings.ToLower(user.EmailAddress), query) || strings.HasPrefix(strings.ToLower(user.DisplayName), query) {
+				matches = append(matches, user)
+			}
+		}
+		writeJSON(w, matches)
+		return true
+	}
+	api := &APIConfig{Name: DefaultAPI, URL: f.URL, APIVersion: APIVersion3}
+	r := testAPIReceiver(t, api, &ReceiverConfig{Fields: map[string]interface{}{
+		"customfield_10010": "jane@example.com",
+		"customfield_10011": []interface{}{map[string]interface{}{"name": "John Doe"}, map[string]interface{}{"accountId": "acc-bob"}},
+	}})
+
+	notify(t, r, testData(nil, testAlert("alertname", "A")))
+	notify(t, r, testData(nil, testAlert("alertname", "B")))
+	issues := f.Issues()
+	if len(issues) != 2 {
+		t.Fatalf("got %d issues, want 2", len(issues))
+	}
+	want := map[string]interface{}{
+		"customfield_10010": map[string]interface{}{"accountId": "acc-jane"},
+		"customfield_10011": []interface{}{map[string]interface{}{"accountId": "acc-john"}, map[string]interface{}{"accountId": "acc-bob"}},
+	}
+	for id, value := range want {
+		if got := issues[0].Fields[id]; !reflect.DeepEqual(got, value) {
+			t.Errorf("got %s %v, want %v", id, got, value)
+		}
+	}
+	if n := f.Requests("/user/search"); n != 2 {
+		t.Errorf("got %d user searches, want 2 as account IDs are cached", n)
+	}
+
+	for _, name := range []string{"John", "Jan", "nobody@example.com"} {
+		if id, err := r.endpoint.accountID(context.Background(), r, name); err == nil {
+			t.Errorf("%s: got account ID %s, want an error as no user matches exactly", name, id)
+		}
+	}
+}